@@ -9,7 +9,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/garyburd/redigo/redis"
 	apachelog "github.com/lestrrat/go-apache-logformat"
 )
 
@@ -21,13 +20,30 @@ type App struct {
 	SlackClientSecret       string
 	SlackClientID           string
 	SlackVerificationToken  string
+	SlackSigningSecret      string
+	SlackBotToken           string
 	StateStoreKey           string
 	SalesforceTokenStoreKey string
 	SlackTokenStoreKey      string
 	NotifyChannelStoreKey   string
+	TimeZoneStoreKey        string
+	ReminderStoreKey        string
+	LeaveNudgeStoreKey      string
+	RestWatchdogStoreKey    string
+	PresenceStoreKey        string
+	EventStoreKey           string
 	TeamSpiritHost          string
-	RedisConn               redis.Conn
+	SalesforceLoginHost     string
+	SalesforceLoginHosts    map[string]string
+	StateTTL                time.Duration
+	TokenCipher             *tokenCipher
+	Store                   Store
 	TimeoutDuration         time.Duration
+	TeamSpiritTimeout       time.Duration
+	LeaveNudgeAfter         time.Duration
+	RestWatchdogAfter       time.Duration
+	PresenceAwayAfter       time.Duration
+	SchedulerDisabled       bool
 }
 
 // New Returns new app
@@ -38,6 +54,7 @@ func new() (*App, error) {
 	slackClientSecret := os.Getenv("SLACK_CLIENT_SECRET")
 	slackClientID := os.Getenv("SLACK_CLIENT_ID")
 	slackVerificationToken := os.Getenv("SLACK_VERIFICATION_TOKEN")
+	slackSigningSecret := os.Getenv("SLACK_SIGNING_SECRET")
 	teamSpilitHost := os.Getenv("TEAMSPIRIT_HOST")
 	var errVars = []string{}
 	if salesforceClientSecret == "" {
@@ -52,7 +69,7 @@ func new() (*App, error) {
 	if slackClientID == "" {
 		errVars = append(errVars, "SLACK_CLIENT_ID")
 	}
-	if slackVerificationToken == "" {
+	if slackVerificationToken == "" && slackSigningSecret == "" {
 		errVars = append(errVars, "SLACK_VERIFICATION_TOKEN")
 	}
 	if teamSpilitHost == "" {
@@ -86,6 +103,42 @@ func new() (*App, error) {
 		app.NotifyChannelStoreKey = "tsdakoku:notify_channels"
 	}
 
+	if k := os.Getenv("TIMEZONE_STORE_KEY"); k != "" {
+		app.TimeZoneStoreKey = k
+	} else {
+		app.TimeZoneStoreKey = "tsdakoku:timezones"
+	}
+
+	if k := os.Getenv("REMINDER_STORE_KEY"); k != "" {
+		app.ReminderStoreKey = k
+	} else {
+		app.ReminderStoreKey = "tsdakoku:reminders"
+	}
+
+	if k := os.Getenv("LEAVE_NUDGE_STORE_KEY"); k != "" {
+		app.LeaveNudgeStoreKey = k
+	} else {
+		app.LeaveNudgeStoreKey = "tsdakoku:leave_nudges"
+	}
+
+	if k := os.Getenv("REST_WATCHDOG_STORE_KEY"); k != "" {
+		app.RestWatchdogStoreKey = k
+	} else {
+		app.RestWatchdogStoreKey = "tsdakoku:rest_watchdogs"
+	}
+
+	if k := os.Getenv("PRESENCE_STORE_KEY"); k != "" {
+		app.PresenceStoreKey = k
+	} else {
+		app.PresenceStoreKey = "tsdakoku:presences"
+	}
+
+	if k := os.Getenv("EVENT_STORE_KEY"); k != "" {
+		app.EventStoreKey = k
+	} else {
+		app.EventStoreKey = "tsdakoku:events"
+	}
+
 	duration, _ := strconv.Atoi(os.Getenv("SALESFORCE_TIMEOUT_MINUTES"))
 	if duration > 0 {
 		app.TimeoutDuration = time.Duration(duration) * time.Minute
@@ -93,18 +146,84 @@ func new() (*App, error) {
 		app.TimeoutDuration = time.Hour
 	}
 
+	timeout, _ := strconv.Atoi(os.Getenv("TEAMSPIRIT_TIMEOUT_SECONDS"))
+	if timeout > 0 {
+		app.TeamSpiritTimeout = time.Duration(timeout) * time.Second
+	} else {
+		app.TeamSpiritTimeout = defaultTeamSpiritTimeout
+	}
+
+	nudgeAfter, _ := strconv.Atoi(os.Getenv("LEAVE_NUDGE_AFTER_MINUTES"))
+	if nudgeAfter > 0 {
+		app.LeaveNudgeAfter = time.Duration(nudgeAfter) * time.Minute
+	} else {
+		app.LeaveNudgeAfter = defaultLeaveNudgeAfter
+	}
+
+	restLimit, _ := strconv.Atoi(os.Getenv("REST_WATCHDOG_MINUTES"))
+	if restLimit > 0 {
+		app.RestWatchdogAfter = time.Duration(restLimit) * time.Minute
+	} else {
+		app.RestWatchdogAfter = defaultRestWatchdogAfter
+	}
+
+	awayAfter, _ := strconv.Atoi(os.Getenv("PRESENCE_AWAY_MINUTES"))
+	if awayAfter > 0 {
+		app.PresenceAwayAfter = time.Duration(awayAfter) * time.Minute
+	} else {
+		app.PresenceAwayAfter = defaultPresenceAwayAfter
+	}
+
+	app.SchedulerDisabled, _ = strconv.ParseBool(os.Getenv("DISABLE_SCHEDULER"))
+
+	ttl, _ := strconv.Atoi(os.Getenv("STATE_TTL_MINUTES"))
+	if ttl > 0 {
+		app.StateTTL = time.Duration(ttl) * time.Minute
+	} else {
+		app.StateTTL = 10 * time.Minute
+	}
+
+	if h := os.Getenv("SALESFORCE_LOGIN_HOST"); h != "" {
+		app.SalesforceLoginHost = h
+	} else {
+		app.SalesforceLoginHost = defaultSalesforceLoginHost
+	}
+	app.SalesforceLoginHosts = parseTeamHosts(os.Getenv("SALESFORCE_LOGIN_HOSTS"))
+
+	if k := os.Getenv("TOKEN_ENCRYPTION_KEY"); k != "" {
+		tokenCipher, err := parseTokenEncryptionKey(k)
+		if err != nil {
+			return app, err
+		}
+		app.TokenCipher = tokenCipher
+	}
+
 	app.SalesforceClientID = salesforceClientID
 	app.SalesforceClientSecret = salesforceClientSecret
 	app.SlackClientID = slackClientID
 	app.SlackClientSecret = slackClientSecret
 	app.SlackVerificationToken = slackVerificationToken
+	app.SlackSigningSecret = slackSigningSecret
+	app.SlackBotToken = os.Getenv("SLACK_BOT_TOKEN")
 	app.TeamSpiritHost = teamSpilitHost
-	if err := app.setupRedis(); err != nil {
+	if err := app.setupStore(); err != nil {
 		return app, err
 	}
 	return app, nil
 }
 
+// parseTeamHosts parses comma separated "teamID=host" pairs
+func parseTeamHosts(value string) map[string]string {
+	hosts := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		pair := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(pair) == 2 && pair[0] != "" && pair[1] != "" {
+			hosts[pair[0]] = pair[1]
+		}
+	}
+	return hosts
+}
+
 // Run starts web server
 func Run() (*App, error) {
 	app, err := new()
@@ -117,6 +236,9 @@ func Run() (*App, error) {
 	}
 	app.Port = port
 	router := app.setupRouter()
+	if !app.SchedulerDisabled {
+		app.startScheduler(make(chan struct{}))
+	}
 	fmt.Println("Listeninng on 0.0.0.0:" + strconv.Itoa(port))
 	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(port), apachelog.CombinedLog.Wrap(router, os.Stderr)))
 	return app, nil
@@ -5,18 +5,18 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"golang.org/x/oauth2"
 )
 
-func (app *App) CleanRedis() {
-	app.RedisConn.Do("DEL", app.SalesforceTokenStoreKey)
-	app.RedisConn.Do("DEL", app.StateStoreKey)
-	app.RedisConn.Do("DEL", app.SlackTokenStoreKey)
-	app.RedisConn.Do("DEL", app.NotifyChannelStoreKey)
+func (app *App) CleanStore() {
+	app.Store = newMemoryStore()
 }
 
 func createMockApp() *App {
 	os.Setenv("STATE_STORE_KEY", "tsdakoku-test:states")
 	os.Setenv("OAUTH_TOKEN_STORE_KEY", "tsdakoku-test:oauth_tokens")
+	os.Setenv("STORE_URL", "memory://")
 	for _, name := range []string{
 		"SALESFORCE_CLIENT_SECRET",
 		"SALESFORCE_CLIENT_ID",
@@ -29,6 +29,18 @@ func createMockApp() *App {
 	return app
 }
 
+// createLoggedInTestContext returns the context of the user FOO at now, who has logged in to TeamSpirit and Slack
+func createLoggedInTestContext(app *App, now time.Time) *Context {
+	ctx := app.createUserContext("T12345678", "FOO", now)
+	ctx.setSalesforceAccessToken(&oauth2.Token{
+		AccessToken:  "foo",
+		RefreshToken: "bar",
+		TokenType:    "Bearer",
+	})
+	ctx.setSlackAccessToken("baz")
+	return ctx
+}
+
 func TestNewApp(t *testing.T) {
 	for _, name := range []string{
 		"SALESFORCE_CLIENT_SECRET",
@@ -42,6 +54,7 @@ func TestNewApp(t *testing.T) {
 	} {
 		os.Setenv(name, "")
 	}
+	os.Setenv("STORE_URL", "memory://")
 	app, err := new()
 	for _, test := range []Test{
 		{false, app == nil},
@@ -65,7 +78,21 @@ func TestNewApp(t *testing.T) {
 		{true, err == nil},
 		{"tsdakoku:states", app.StateStoreKey},
 		{"tsdakoku:oauth_tokens", app.SalesforceTokenStoreKey},
+		{"tsdakoku:timezones", app.TimeZoneStoreKey},
+		{"tsdakoku:reminders", app.ReminderStoreKey},
+		{"tsdakoku:leave_nudges", app.LeaveNudgeStoreKey},
+		{2 * time.Hour, app.LeaveNudgeAfter},
+		{"tsdakoku:rest_watchdogs", app.RestWatchdogStoreKey},
+		{90 * time.Minute, app.RestWatchdogAfter},
+		{"tsdakoku:presences", app.PresenceStoreKey},
+		{"tsdakoku:events", app.EventStoreKey},
+		{15 * time.Minute, app.PresenceAwayAfter},
+		{false, app.SchedulerDisabled},
+		{"", app.SlackBotToken},
 		{time.Hour, app.TimeoutDuration},
+		{30 * time.Second, app.TeamSpiritTimeout},
+		{"login.salesforce.com", app.SalesforceLoginHost},
+		{0, len(app.SalesforceLoginHosts)},
 	} {
 		test.Compare(t)
 	}
@@ -73,7 +100,21 @@ func TestNewApp(t *testing.T) {
 	os.Setenv("OAUTH_TOKEN_STORE_KEY", "tsdakoku-test:oauth_tokens")
 	os.Setenv("SLACK_TOKEN_STORE_KEY", "tsdakoku-test:slack_tokens")
 	os.Setenv("SLACK_NOTIFY_CHANNEL_STORE_KEY", "tsdakoku-test:notify_channels")
+	os.Setenv("TIMEZONE_STORE_KEY", "tsdakoku-test:timezones")
+	os.Setenv("REMINDER_STORE_KEY", "tsdakoku-test:reminders")
+	os.Setenv("LEAVE_NUDGE_STORE_KEY", "tsdakoku-test:leave_nudges")
+	os.Setenv("LEAVE_NUDGE_AFTER_MINUTES", "90")
+	os.Setenv("REST_WATCHDOG_STORE_KEY", "tsdakoku-test:rest_watchdogs")
+	os.Setenv("REST_WATCHDOG_MINUTES", "60")
+	os.Setenv("PRESENCE_STORE_KEY", "tsdakoku-test:presences")
+	os.Setenv("EVENT_STORE_KEY", "tsdakoku-test:events")
+	os.Setenv("PRESENCE_AWAY_MINUTES", "30")
+	os.Setenv("DISABLE_SCHEDULER", "true")
+	os.Setenv("SLACK_BOT_TOKEN", "xoxb-test")
 	os.Setenv("SALESFORCE_TIMEOUT_MINUTES", "20")
+	os.Setenv("TEAMSPIRIT_TIMEOUT_SECONDS", "10")
+	os.Setenv("SALESFORCE_LOGIN_HOST", "test.salesforce.com")
+	os.Setenv("SALESFORCE_LOGIN_HOSTS", "T123=example.my.salesforce.com")
 	app, err = new()
 	for _, test := range []Test{
 		{false, app == nil},
@@ -82,11 +123,33 @@ func TestNewApp(t *testing.T) {
 		{"tsdakoku-test:oauth_tokens", app.SalesforceTokenStoreKey},
 		{"tsdakoku-test:slack_tokens", app.SlackTokenStoreKey},
 		{"tsdakoku-test:notify_channels", app.NotifyChannelStoreKey},
+		{"tsdakoku-test:timezones", app.TimeZoneStoreKey},
+		{"tsdakoku-test:reminders", app.ReminderStoreKey},
+		{"tsdakoku-test:leave_nudges", app.LeaveNudgeStoreKey},
+		{90 * time.Minute, app.LeaveNudgeAfter},
+		{"tsdakoku-test:rest_watchdogs", app.RestWatchdogStoreKey},
+		{time.Hour, app.RestWatchdogAfter},
+		{"tsdakoku-test:presences", app.PresenceStoreKey},
+		{"tsdakoku-test:events", app.EventStoreKey},
+		{30 * time.Minute, app.PresenceAwayAfter},
+		{true, app.SchedulerDisabled},
+		{"xoxb-test", app.SlackBotToken},
 		{20 * time.Minute, app.TimeoutDuration},
+		{10 * time.Second, app.TeamSpiritTimeout},
+		{"test.salesforce.com", app.SalesforceLoginHost},
+		{"example.my.salesforce.com", app.SalesforceLoginHosts["T123"]},
 	} {
 		test.Compare(t)
 	}
+	os.Setenv("SALESFORCE_LOGIN_HOST", "")
+	os.Setenv("SALESFORCE_LOGIN_HOSTS", "")
+	os.Setenv("SLACK_BOT_TOKEN", "")
+	os.Setenv("LEAVE_NUDGE_AFTER_MINUTES", "")
+	os.Setenv("REST_WATCHDOG_MINUTES", "")
+	os.Setenv("PRESENCE_AWAY_MINUTES", "")
+	os.Setenv("DISABLE_SCHEDULER", "")
 	os.Setenv("SALESFORCE_TIMEOUT_MINUTES", "100hoge")
+	os.Setenv("TEAMSPIRIT_TIMEOUT_SECONDS", "")
 
 	app, err = new()
 	for _, test := range []Test{
@@ -95,7 +158,17 @@ func TestNewApp(t *testing.T) {
 		test.Compare(t)
 	}
 
+	os.Setenv("STORE_URL", "foo://bar")
+	app, err = new()
+	for _, test := range []Test{
+		{false, app == nil},
+		{"Unsupported store URL: foo://bar", err.Error()},
+	} {
+		test.Compare(t)
+	}
+
 	origiinalRedisURL := os.Getenv("REDIS_URL")
+	os.Setenv("STORE_URL", "")
 	os.Setenv("REDIS_URL", "redis://hoge")
 	os.Setenv("SALESFORCE_TIMEOUT_MINUTES", "")
 	app, err = new()
@@ -106,6 +179,14 @@ func TestNewApp(t *testing.T) {
 		test.Compare(t)
 	}
 	os.Setenv("REDIS_URL", origiinalRedisURL)
+	os.Setenv("STORE_URL", "memory://")
+}
+
+func TestParseTeamHosts(t *testing.T) {
+	Test{map[string]string{
+		"T123": "test.salesforce.com",
+		"T456": "example.my.salesforce.com",
+	}, parseTeamHosts("T123=test.salesforce.com, T456=example.my.salesforce.com,T789=,foo")}.DeepEqual(t)
 }
 
 func TestRun(t *testing.T) {
@@ -0,0 +1,131 @@
+package app
+
+import "github.com/nlopes/slack"
+
+// message is a Slack message with Block Kit blocks
+type message struct {
+	slack.Msg
+	Blocks []block `json:"blocks,omitempty"`
+}
+
+type textObject struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type confirmObject struct {
+	Title   *textObject `json:"title"`
+	Text    *textObject `json:"text"`
+	Confirm *textObject `json:"confirm"`
+	Deny    *textObject `json:"deny"`
+}
+
+type optionObject struct {
+	Text  *textObject `json:"text"`
+	Value string      `json:"value"`
+}
+
+type blockElement struct {
+	Type        string          `json:"type"`
+	ActionID    string          `json:"action_id,omitempty"`
+	Text        *textObject     `json:"text,omitempty"`
+	Value       string          `json:"value,omitempty"`
+	URL         string          `json:"url,omitempty"`
+	Style       string          `json:"style,omitempty"`
+	Placeholder *textObject     `json:"placeholder,omitempty"`
+	Options     []*optionObject `json:"options,omitempty"`
+	Confirm     *confirmObject  `json:"confirm,omitempty"`
+}
+
+type block struct {
+	Type      string        `json:"type"`
+	BlockID   string        `json:"block_id,omitempty"`
+	Text      *textObject   `json:"text,omitempty"`
+	Accessory *blockElement `json:"accessory,omitempty"`
+	// Elements are *blockElement for actions blocks and *textObject for context blocks
+	Elements []interface{} `json:"elements,omitempty"`
+}
+
+func plainText(text string) *textObject {
+	return &textObject{Type: "plain_text", Text: text}
+}
+
+func markdownText(text string) *textObject {
+	return &textObject{Type: "mrkdwn", Text: text}
+}
+
+func sectionBlock(text string) block {
+	return block{Type: "section", Text: markdownText(text)}
+}
+
+func contextBlock(texts ...string) block {
+	elements := []interface{}{}
+	for _, text := range texts {
+		elements = append(elements, markdownText(text))
+	}
+	return block{Type: "context", Elements: elements}
+}
+
+func actionsBlock(blockID string, elements ...*blockElement) block {
+	items := []interface{}{}
+	for _, element := range elements {
+		items = append(items, element)
+	}
+	return block{Type: "actions", BlockID: blockID, Elements: items}
+}
+
+func buttonElement(actionID, text, style string) *blockElement {
+	return &blockElement{
+		Type:     "button",
+		ActionID: actionID,
+		Value:    actionID,
+		Text:     plainText(text),
+		Style:    style,
+	}
+}
+
+func confirmDialog(title, text, confirm, deny string) *confirmObject {
+	return &confirmObject{
+		Title:   plainText(title),
+		Text:    plainText(text),
+		Confirm: plainText(confirm),
+		Deny:    plainText(deny),
+	}
+}
+
+func conversationsSelectElement(actionID, placeholder string) *blockElement {
+	return &blockElement{
+		Type:        "conversations_select",
+		ActionID:    actionID,
+		Placeholder: plainText(placeholder),
+	}
+}
+
+func staticSelectElement(actionID, placeholder string, options ...*optionObject) *blockElement {
+	return &blockElement{
+		Type:        "static_select",
+		ActionID:    actionID,
+		Placeholder: plainText(placeholder),
+		Options:     options,
+	}
+}
+
+func selectOption(text, value string) *optionObject {
+	return &optionObject{Text: plainText(text), Value: value}
+}
+
+// getActions returns the interactive elements in the message
+func (msg *message) getActions() []*blockElement {
+	elements := []*blockElement{}
+	for _, b := range msg.Blocks {
+		if b.Type != "actions" {
+			continue
+		}
+		for _, e := range b.Elements {
+			if element, ok := e.(*blockElement); ok {
+				elements = append(elements, element)
+			}
+		}
+	}
+	return elements
+}
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMarshalBlocks(t *testing.T) {
+	button := buttonElement(actionTypeLeave, "退勤する", "danger")
+	button.Confirm = confirmDialog("退勤", "退勤しますか？", "はい", "いいえ")
+	b, _ := json.Marshal([]block{
+		sectionBlock("*foo*"),
+		contextBlock("bar", "baz"),
+		actionsBlock(callbackIDAttendanceButton,
+			button,
+			conversationsSelectElement(actionTypeSelectChannel, "チャネルを選択"),
+		),
+	})
+	Test{`[{"type":"section","text":{"type":"mrkdwn","text":"*foo*"}},` +
+		`{"type":"context","elements":[{"type":"mrkdwn","text":"bar"},{"type":"mrkdwn","text":"baz"}]},` +
+		`{"type":"actions","block_id":"attendance_button","elements":[` +
+		`{"type":"button","action_id":"leave","text":{"type":"plain_text","text":"退勤する"},"value":"leave","style":"danger",` +
+		`"confirm":{"title":{"type":"plain_text","text":"退勤"},"text":{"type":"plain_text","text":"退勤しますか？"},"confirm":{"type":"plain_text","text":"はい"},"deny":{"type":"plain_text","text":"いいえ"}}},` +
+		`{"type":"conversations_select","action_id":"select-channel","placeholder":{"type":"plain_text","text":"チャネルを選択"}}]}]`, string(b)}.Compare(t)
+}
+
+func TestMarshalStaticSelect(t *testing.T) {
+	b, _ := json.Marshal(staticSelectElement(actionTypeUnrestAt, "時刻を選択", selectOption("12:15", "735")))
+	Test{`{"type":"static_select","action_id":"unrest-at","placeholder":{"type":"plain_text","text":"時刻を選択"},` +
+		`"options":[{"text":{"type":"plain_text","text":"12:15"},"value":"735"}]}`, string(b)}.Compare(t)
+}
+
+func TestGetActions(t *testing.T) {
+	msg := &message{
+		Blocks: []block{
+			sectionBlock("foo"),
+			actionsBlock("bar", buttonElement("baz", "Baz", "")),
+			actionsBlock("qux", buttonElement("quux", "Quux", "")),
+		},
+	}
+	actions := msg.getActions()
+	for _, test := range []Test{
+		{2, len(actions)},
+		{"baz", actions[0].ActionID},
+		{"quux", actions[1].ActionID},
+	} {
+		test.Compare(t)
+	}
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type boltStore struct {
+	DB *bolt.DB
+}
+
+func newBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err == bolt.ErrTimeout {
+		// the file is locked exclusively by the process which opened it
+		return nil, fmt.Errorf("%s is used by another process. The Bolt store can be opened by only one process, which runs both the web server and the scheduler", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{DB: db}, nil
+}
+
+func (s *boltStore) Get(hashKey, field string) (string, error) {
+	value := ""
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte(hashKey)); bucket != nil {
+			value = string(bucket.Get([]byte(field)))
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (s *boltStore) Set(hashKey, field, value string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(hashKey))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(field), []byte(value))
+	})
+}
+
+func (s *boltStore) SetIfAbsent(hashKey, field, value string) (bool, error) {
+	set := false
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(hashKey))
+		if err != nil || bucket.Get([]byte(field)) != nil {
+			return err
+		}
+		set = true
+		return bucket.Put([]byte(field), []byte(value))
+	})
+	return set && err == nil, err
+}
+
+func (s *boltStore) Delete(hashKey, field string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte(hashKey)); bucket != nil {
+			return bucket.Delete([]byte(field))
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Take(hashKey, field string) (string, error) {
+	value := ""
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(hashKey))
+		if bucket == nil {
+			return nil
+		}
+		value = string(bucket.Get([]byte(field)))
+		return bucket.Delete([]byte(field))
+	})
+	return value, err
+}
+
+func (s *boltStore) All(hashKey string) (map[string]string, error) {
+	values := map[string]string{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(hashKey))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			values[string(k)] = string(v)
+			return nil
+		})
+	})
+	return values, err
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ts-dakoku")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	store, err := newStore("bolt://" + filepath.Join(dir, "ts-dakoku.db"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, ok := store.(*boltStore)
+	Test{true, ok}.Compare(t)
+	testStore(t, store)
+}
+
+func TestBoltStoreLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ts-dakoku")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ts-dakoku.db")
+	store, err := newBoltStore(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.DB.Close()
+	_, err = newBoltStore(path)
+	Test{true, err != nil && strings.HasPrefix(err.Error(), path+" is used by another process.")}.Compare(t)
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"encoding/json"
+
+	"github.com/nlopes/slack"
+)
+
+const callbackTypeBlockActions = "block_actions"
+
+type blockActionCallback struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+	Team  struct {
+		ID string `json:"id"`
+	} `json:"team"`
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+	ResponseURL string        `json:"response_url"`
+	Actions     []blockAction `json:"actions"`
+}
+
+type blockAction struct {
+	ActionID             string        `json:"action_id"`
+	BlockID              string        `json:"block_id"`
+	Value                string        `json:"value"`
+	SelectedConversation string        `json:"selected_conversation"`
+	SelectedOption       *optionObject `json:"selected_option"`
+}
+
+// actionCallback is an interactive action from either Block Kit or legacy attachment messages
+type actionCallback struct {
+	Token         string
+	TeamID        string
+	UserID        string
+	ResponseURL   string
+	CallbackID    string
+	ActionName    string
+	SelectedValue string
+	IsBlockAction bool
+}
+
+func parseActionCallback(payload []byte) (*actionCallback, error) {
+	var probe struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return nil, err
+	}
+	if probe.Type == callbackTypeBlockActions {
+		var data blockActionCallback
+		if err := json.Unmarshal(payload, &data); err != nil {
+			return nil, err
+		}
+		callback := &actionCallback{
+			Token:         data.Token,
+			TeamID:        data.Team.ID,
+			UserID:        data.User.ID,
+			ResponseURL:   data.ResponseURL,
+			IsBlockAction: true,
+		}
+		if len(data.Actions) > 0 {
+			action := data.Actions[0]
+			callback.CallbackID = action.BlockID
+			callback.ActionName = action.ActionID
+			callback.SelectedValue = action.Value
+			if action.SelectedConversation != "" {
+				callback.SelectedValue = action.SelectedConversation
+			} else if action.SelectedOption != nil {
+				callback.SelectedValue = action.SelectedOption.Value
+			}
+		}
+		return callback, nil
+	}
+	var data slack.AttachmentActionCallback
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, err
+	}
+	callback := &actionCallback{
+		Token:       data.Token,
+		TeamID:      data.Team.ID,
+		UserID:      data.User.ID,
+		ResponseURL: data.ResponseURL,
+		CallbackID:  data.CallbackID,
+	}
+	if len(data.Actions) > 0 {
+		action := data.Actions[0]
+		callback.ActionName = action.Name
+		callback.SelectedValue = action.Value
+		if len(action.SelectedOptions) > 0 {
+			callback.SelectedValue = action.SelectedOptions[0].Value
+		}
+	}
+	return callback, nil
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"testing"
+)
+
+func TestParseActionCallback(t *testing.T) {
+	data, err := parseActionCallback([]byte(`{
+		"type": "block_actions",
+		"token": "TOKEN",
+		"team": {"id": "T12345678"},
+		"user": {"id": "U12345678"},
+		"response_url": "https://hooks.slack.test/coolhook",
+		"actions": [{"block_id": "slack_channel_select_button", "action_id": "select-channel", "selected_conversation": "C1234567"}]
+	}`))
+	for _, test := range []Test{
+		{true, err == nil},
+		{"TOKEN", data.Token},
+		{"T12345678", data.TeamID},
+		{"U12345678", data.UserID},
+		{"https://hooks.slack.test/coolhook", data.ResponseURL},
+		{callbackIDChannelSelect, data.CallbackID},
+		{actionTypeSelectChannel, data.ActionName},
+		{"C1234567", data.SelectedValue},
+		{true, data.IsBlockAction},
+	} {
+		test.Compare(t)
+	}
+	data, err = parseActionCallback([]byte(`{
+		"type": "block_actions",
+		"actions": [{"block_id": "foo", "action_id": "bar", "selected_option": {"value": "baz"}}]
+	}`))
+	for _, test := range []Test{
+		{true, err == nil},
+		{"foo", data.CallbackID},
+		{"bar", data.ActionName},
+		{"baz", data.SelectedValue},
+	} {
+		test.Compare(t)
+	}
+	data, err = parseActionCallback([]byte(`{
+		"type": "interactive_message",
+		"token": "TOKEN",
+		"callback_id": "attendance_button",
+		"team": {"id": "T12345678"},
+		"user": {"id": "U12345678"},
+		"response_url": "https://hooks.slack.test/coolhook",
+		"actions": [{"name": "attend", "value": "attend"}]
+	}`))
+	for _, test := range []Test{
+		{true, err == nil},
+		{"TOKEN", data.Token},
+		{"T12345678", data.TeamID},
+		{"U12345678", data.UserID},
+		{callbackIDAttendanceButton, data.CallbackID},
+		{actionTypeAttend, data.ActionName},
+		{actionTypeAttend, data.SelectedValue},
+		{false, data.IsBlockAction},
+	} {
+		test.Compare(t)
+	}
+	_, err = parseActionCallback([]byte(""))
+	Test{"unexpected end of JSON input", err.Error()}.Compare(t)
+}
@@ -0,0 +1,240 @@
+package app
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nlopes/slack"
+)
+
+// subcommand is a subcommand of the /ts slash command
+type subcommand struct {
+	Name        string
+	Arguments   string
+	Description string
+	// MaxArgs is the maximum number of arguments the subcommand accepts
+	MaxArgs int
+	// Authorized subcommands require a valid TeamSpirit session
+	Authorized bool
+	Handler    func(ctx *Context, input *commandInput) (*message, error)
+}
+
+// commandInput is the parsed slash command passed to subcommand handlers
+type commandInput struct {
+	Name           string
+	Args           []string
+	State          State
+	RequestContext context.Context
+	Client         *timeTableClient
+	TimeTable      *timeTable
+}
+
+const defaultSubcommandName = "status"
+
+var subcommands []*subcommand
+
+func init() {
+	subcommands = []*subcommand{
+		{
+			Name:        "help",
+			Arguments:   "[サブコマンド]",
+			Description: "使い方を表示します",
+			MaxArgs:     1,
+			Handler:     (*Context).getHelpSlackMessage,
+		},
+		{
+			Name:        "status",
+			Description: "本日の勤務状況と打刻ボタンを表示します",
+			Authorized:  true,
+			Handler:     (*Context).getStatusSlackMessage,
+		},
+		{
+			Name:        "in",
+			Arguments:   "[9:05]",
+			MaxArgs:     1,
+			Description: "出勤します",
+			Authorized:  true,
+			Handler:     getPunchSubcommandHandler(actionTypeAttend),
+		},
+		{
+			Name:        "out",
+			Arguments:   "[18:30]",
+			MaxArgs:     1,
+			Description: "退勤します",
+			Authorized:  true,
+			Handler:     getPunchSubcommandHandler(actionTypeLeave),
+		},
+		{
+			Name:        "break",
+			Arguments:   "[12:00[-13:00]]",
+			MaxArgs:     1,
+			Description: "休憩を開始します",
+			Authorized:  true,
+			Handler:     getPunchSubcommandHandler(actionTypeRest),
+		},
+		{
+			Name:        "back",
+			Arguments:   "[13:00]",
+			MaxArgs:     1,
+			Description: "休憩・私用外出を終了します",
+			Authorized:  true,
+			Handler:     getPunchSubcommandHandler(actionTypeUnrest),
+		},
+		{
+			Name:        "away",
+			Arguments:   "[14:00[-15:00]]",
+			Description: "私用外出を開始します",
+			MaxArgs:     1,
+			Authorized:  true,
+			Handler:     getPunchSubcommandHandler(actionTypeAway),
+		},
+		{
+			Name:        "login",
+			Description: "TeamSpirit で認証します",
+			Handler: func(ctx *Context, input *commandInput) (*message, error) {
+				return ctx.getLoginSlackMessage(input.State)
+			},
+		},
+		{
+			Name:        "logout",
+			Description: "TeamSpirit と Slack の連携を解除します",
+			Handler:     (*Context).getLogoutSlackMessage,
+		},
+		{
+			Name:        "channel",
+			Description: "打刻時に通知するチャネルを設定します",
+			Authorized:  true,
+			Handler: func(ctx *Context, input *commandInput) (*message, error) {
+				token, err := ctx.getSlackAccessTokenForUser()
+				if err != nil {
+					return nil, err
+				}
+				if token == "" {
+					return ctx.getAuthenticateSlackMessage(input.State, input.Name)
+				}
+				return ctx.getChannelSelectSlackMessage()
+			},
+		},
+		{
+			Name:        "tz",
+			Arguments:   "[Asia/Tokyo]",
+			Description: "打刻に使うタイムゾーンを表示・設定します",
+			MaxArgs:     1,
+			Handler:     (*Context).getTimeZoneSlackMessage,
+		},
+		{
+			Name:        "remind",
+			Arguments:   "[9:30|off]",
+			Description: "勤務日に出勤していなければ DM でお知らせする時刻を表示・設定します",
+			MaxArgs:     1,
+			Handler:     (*Context).getRemindSlackMessage,
+		},
+		{
+			Name:        "presence",
+			Arguments:   "[on|off]",
+			Description: "Slack のプレゼンスに応じて出勤・休憩を DM で提案するかを表示・設定します",
+			MaxArgs:     1,
+			Handler:     (*Context).getPresenceSlackMessage,
+		},
+	}
+}
+
+func findSubcommand(name string) *subcommand {
+	for _, cmd := range subcommands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func parseCommandText(text string) (string, []string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return defaultSubcommandName, []string{}
+	}
+	return strings.ToLower(fields[0]), fields[1:]
+}
+
+func (cmd *subcommand) getUsage() string {
+	usage := "/ts " + cmd.Name
+	if cmd.Arguments != "" {
+		usage += " " + cmd.Arguments
+	}
+	return usage
+}
+
+func getErrorSlackMessage(text string) *message {
+	return &message{
+		Msg: slack.Msg{
+			ResponseType: "ephemeral",
+			Text:         text,
+		},
+		Blocks: []block{sectionBlock(text)},
+	}
+}
+
+func getEphemeralSlackMessage(text string) *message {
+	return &message{
+		Msg: slack.Msg{
+			ResponseType: "ephemeral",
+			Text:         text,
+		},
+	}
+}
+
+func (ctx *Context) getHelpSlackMessage(input *commandInput) (*message, error) {
+	cmds := subcommands
+	if len(input.Args) > 0 {
+		cmd := findSubcommand(strings.ToLower(input.Args[0]))
+		if cmd == nil {
+			return getUnknownCommandSlackMessage(input.Args[0]), nil
+		}
+		cmds = []*subcommand{cmd}
+	}
+	lines := []string{}
+	for _, cmd := range cmds {
+		lines = append(lines, "`"+cmd.getUsage()+"` "+cmd.Description)
+	}
+	text := strings.Join(lines, "\n")
+	return &message{
+		Msg: slack.Msg{
+			ResponseType: "ephemeral",
+			Text:         text,
+		},
+		Blocks: []block{
+			sectionBlock(text),
+			contextBlock("サブコマンドを省略すると `/ts " + defaultSubcommandName + "` を実行します"),
+		},
+	}, nil
+}
+
+func getUnknownCommandSlackMessage(name string) *message {
+	return getErrorSlackMessage("`/ts " + name + "` は不明なコマンドです。`/ts help` で使い方を確認してください :bow:")
+}
+
+func (ctx *Context) runSubcommand(input *commandInput) (*message, error) {
+	cmd := findSubcommand(input.Name)
+	if cmd == nil {
+		return getUnknownCommandSlackMessage(input.Name), nil
+	}
+	if len(input.Args) > cmd.MaxArgs {
+		return getErrorSlackMessage("引数が多すぎます。使い方: `" + cmd.getUsage() + "`"), nil
+	}
+	reqCtx, cancel := ctx.newRequestContext()
+	defer cancel()
+	input.RequestContext = reqCtx
+	if cmd.Authorized {
+		client, err := ctx.createTimeTableClient(reqCtx)
+		if err != nil {
+			return ctx.getTimeTableErrorSlackMessage(input.State, err)
+		}
+		timeTable, err := client.GetCurrentTimeTable(reqCtx, ctx.localNow())
+		if err != nil {
+			return ctx.getTimeTableErrorSlackMessage(input.State, err)
+		}
+		input.Client = client
+		input.TimeTable = timeTable
+	}
+	return cmd.Handler(ctx, input)
+}
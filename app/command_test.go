@@ -0,0 +1,128 @@
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+func TestParseCommandText(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		name string
+		args []string
+	}{
+		{"", "status", []string{}},
+		{"  ", "status", []string{}},
+		{"help", "help", []string{}},
+		{"HELP Status", "help", []string{"Status"}},
+		{" channel  foo bar ", "channel", []string{"foo", "bar"}},
+	} {
+		name, args := parseCommandText(test.text)
+		Test{test.name, name}.Compare(t)
+		Test{test.args, args}.DeepEqual(t)
+	}
+}
+
+func TestFindSubcommand(t *testing.T) {
+	for _, test := range []Test{
+		{"help", findSubcommand("help").Name},
+		{"status", findSubcommand("status").Name},
+		{"login", findSubcommand("login").Name},
+		{"channel", findSubcommand("channel").Name},
+		{true, findSubcommand("foo") == nil},
+		{"/ts help [サブコマンド]", findSubcommand("help").getUsage()},
+		{"/ts status", findSubcommand("status").getUsage()},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestGetHelpSlackMessage(t *testing.T) {
+	app := createMockApp()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/slash", bytes.NewBufferString(""))
+	ctx := app.createContext(req)
+	msg, err := ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", Text: "help"})
+	for _, test := range []Test{
+		{true, err == nil},
+		{"ephemeral", msg.ResponseType},
+		{len(subcommands), len(strings.Split(msg.Text, "\n"))},
+		{0, strings.Index(msg.Text, "`/ts help [サブコマンド]` 使い方を表示します\n")},
+		{true, strings.Contains(msg.Text, "`/ts channel` 打刻時に通知するチャネルを設定します")},
+		{"サブコマンドを省略すると `/ts status` を実行します", msg.Blocks[1].Elements[0].(*textObject).Text},
+	} {
+		test.Compare(t)
+	}
+	msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", Text: "help login"})
+	for _, test := range []Test{
+		{true, err == nil},
+		{"`/ts login` TeamSpirit で認証します", msg.Text},
+	} {
+		test.Compare(t)
+	}
+	msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", Text: "help foo"})
+	for _, test := range []Test{
+		{true, err == nil},
+		{"`/ts foo` は不明なコマンドです。`/ts help` で使い方を確認してください :bow:", msg.Text},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestRunSubcommandErrors(t *testing.T) {
+	app := createMockApp()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/slash", bytes.NewBufferString(""))
+	ctx := app.createContext(req)
+	msg, err := ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", Text: "foo bar"})
+	for _, test := range []Test{
+		{true, err == nil},
+		{"ephemeral", msg.ResponseType},
+		{"`/ts foo` は不明なコマンドです。`/ts help` で使い方を確認してください :bow:", msg.Text},
+		{msg.Text, msg.Blocks[0].Text.Text},
+	} {
+		test.Compare(t)
+	}
+	msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", Text: "status now"})
+	for _, test := range []Test{
+		{true, err == nil},
+		{"引数が多すぎます。使い方: `/ts status`", msg.Text},
+	} {
+		test.Compare(t)
+	}
+	msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", Text: "login"})
+	for _, test := range []Test{
+		{true, err == nil},
+		{"TeamSpirit で認証を行って、再度 `/ts` コマンドを実行してください :bow:", msg.Blocks[0].Text.Text},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestLogoutSubcommand(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/slash", bytes.NewBufferString(""))
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	msg, err := ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", UserID: "FOO", Text: "logout"})
+	for _, test := range []Test{
+		{true, err == nil},
+		{"ephemeral", msg.ResponseType},
+		{"連携しているアカウントはありません", msg.Text},
+	} {
+		test.Compare(t)
+	}
+	ctx.setVariableInHash(ctx.NotifyChannelStoreKey, "C1234567")
+	msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", UserID: "FOO", Text: "logout"})
+	channel, _ := ctx.getSlackNotifyChannelForUser()
+	for _, test := range []Test{
+		{true, err == nil},
+		{"連携しているアカウントはありません", msg.Text},
+		{"", channel},
+	} {
+		test.Compare(t)
+	}
+}
@@ -1,35 +1,57 @@
 package app
 
 import (
+	"context"
 	"net/http"
 	"time"
-
-	"github.com/garyburd/redigo/redis"
 )
 
+// responseURLDeadline leaves a minute of the 30 minutes Slack accepts responses to the response_url
+const responseURLDeadline = 29 * time.Minute
+
 // Context in request
 type Context struct {
-	RedisConn               redis.Conn
+	Store                   Store
 	Request                 *http.Request
 	SalesforceClientSecret  string
 	SalesforceClientID      string
 	SlackClientSecret       string
 	SlackClientID           string
 	UserID                  string
+	TeamID                  string
 	StateStoreKey           string
 	SalesforceTokenStoreKey string
 	SlackTokenStoreKey      string
 	NotifyChannelStoreKey   string
+	TimeZoneStoreKey        string
+	ReminderStoreKey        string
+	LeaveNudgeStoreKey      string
+	RestWatchdogStoreKey    string
+	PresenceStoreKey        string
+	EventStoreKey           string
 	TeamSpiritHost          string
+	SalesforceLoginHost     string
+	SalesforceLoginHosts    map[string]string
 	SlackVerificationToken  string
+	SlackBotToken           string
 	TimeoutDuration         time.Duration
+	TeamSpiritTimeout       time.Duration
+	LeaveNudgeAfter         time.Duration
+	RestWatchdogAfter       time.Duration
+	PresenceAwayAfter       time.Duration
+	StateTTL                time.Duration
+	TokenCipher             *tokenCipher
 	TimeTableClient         *timeTableClient
 	randomString            func(len int) string
+	now                     func() time.Time
+	deadline                time.Time
+	location                *time.Location
+	locationResolved        bool
 }
 
 func (app *App) createContext(r *http.Request) *Context {
 	return &Context{
-		RedisConn:               app.RedisConn,
+		Store:                   app.Store,
 		SalesforceClientID:      app.SalesforceClientID,
 		SalesforceClientSecret:  app.SalesforceClientSecret,
 		SlackClientID:           app.SlackClientID,
@@ -38,26 +60,76 @@ func (app *App) createContext(r *http.Request) *Context {
 		SalesforceTokenStoreKey: app.SalesforceTokenStoreKey,
 		SlackTokenStoreKey:      app.SlackTokenStoreKey,
 		NotifyChannelStoreKey:   app.NotifyChannelStoreKey,
+		TimeZoneStoreKey:        app.TimeZoneStoreKey,
+		ReminderStoreKey:        app.ReminderStoreKey,
+		LeaveNudgeStoreKey:      app.LeaveNudgeStoreKey,
+		RestWatchdogStoreKey:    app.RestWatchdogStoreKey,
+		PresenceStoreKey:        app.PresenceStoreKey,
+		EventStoreKey:           app.EventStoreKey,
 		TeamSpiritHost:          app.TeamSpiritHost,
+		SalesforceLoginHost:     app.SalesforceLoginHost,
+		SalesforceLoginHosts:    app.SalesforceLoginHosts,
 		SlackVerificationToken:  app.SlackVerificationToken,
+		SlackBotToken:           app.SlackBotToken,
 		TimeoutDuration:         app.TimeoutDuration,
+		TeamSpiritTimeout:       app.TeamSpiritTimeout,
+		LeaveNudgeAfter:         app.LeaveNudgeAfter,
+		RestWatchdogAfter:       app.RestWatchdogAfter,
+		PresenceAwayAfter:       app.PresenceAwayAfter,
+		StateTTL:                app.StateTTL,
+		TokenCipher:             app.TokenCipher,
 		Request:                 r,
 		randomString:            randomString,
+		now:                     time.Now,
+		deadline:                time.Now().Add(responseURLDeadline),
 	}
 }
 
-func (ctx *Context) getVariableInHash(hashKey string, key string) string {
-	res, err := ctx.RedisConn.Do("HGET", hashKey, key)
+// newRequestContext returns a context that is done before the response_url of the request expires
+func (ctx *Context) newRequestContext() (context.Context, context.CancelFunc) {
+	return context.WithDeadline(context.Background(), ctx.deadline)
+}
+
+// getVariableInHash returns the value of the key, or an empty string if it is not stored
+func (ctx *Context) getVariableInHash(hashKey string, key string) (string, error) {
+	value, err := ctx.Store.Get(hashKey, key)
 	if err != nil {
-		return ""
+		return "", &storeError{err}
+	}
+	return value, nil
+}
+
+func (ctx *Context) setVariableInHash(hashKey string, value string) error {
+	return ctx.Store.Set(hashKey, ctx.UserID, value)
+}
+
+func (ctx *Context) deleteVariableInHash(hashKey string) error {
+	return ctx.Store.Delete(hashKey, ctx.UserID)
+}
+
+// getTokenInHash returns the decrypted token of the user, or an empty string if it is not stored or cannot be decrypted
+func (ctx *Context) getTokenInHash(hashKey string) (string, error) {
+	value, err := ctx.getVariableInHash(hashKey, ctx.UserID)
+	if err != nil || !isEncryptedToken(value) {
+		return value, err
+	}
+	if ctx.TokenCipher == nil {
+		return "", nil
 	}
-	if data, ok := res.([]byte); ok {
-		return string(data)
+	token, err := ctx.TokenCipher.Decrypt(ctx.UserID, value)
+	if err != nil {
+		return "", nil
 	}
-	return ""
+	return token, nil
 }
 
-func (ctx *Context) setVariableInHash(hashKey string, value interface{}) error {
-	_, err := redis.Bool(ctx.RedisConn.Do("HSET", hashKey, ctx.UserID, value))
-	return err
+func (ctx *Context) setTokenInHash(hashKey string, token string) error {
+	if ctx.TokenCipher == nil {
+		return ctx.setVariableInHash(hashKey, token)
+	}
+	value, err := ctx.TokenCipher.Encrypt(ctx.UserID, token)
+	if err != nil {
+		return err
+	}
+	return ctx.setVariableInHash(hashKey, value)
 }
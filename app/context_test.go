@@ -3,6 +3,7 @@ package app
 import (
 	"net/http"
 	"testing"
+	"time"
 )
 
 func TestCreateContext(t *testing.T) {
@@ -11,7 +12,7 @@ func TestCreateContext(t *testing.T) {
 	ctx := app.createContext(req)
 
 	for _, test := range []Test{
-		{false, ctx.RedisConn == nil},
+		{false, ctx.Store == nil},
 		{"SALESFORCE_CLIENT_ID is set!", ctx.SalesforceClientID},
 		{"SALESFORCE_CLIENT_SECRET is set!", ctx.SalesforceClientSecret},
 		{"tsdakoku-test:states", ctx.StateStoreKey},
@@ -23,3 +24,21 @@ func TestCreateContext(t *testing.T) {
 		test.Compare(t)
 	}
 }
+
+func TestNewRequestContext(t *testing.T) {
+	app := createMockApp()
+	ctx := app.createContext(nil)
+	reqCtx, cancel := ctx.newRequestContext()
+	deadline, ok := reqCtx.Deadline()
+	for _, test := range []Test{
+		{true, ok},
+		{true, deadline.Equal(ctx.deadline)},
+		{true, time.Until(deadline) > 28*time.Minute},
+		{true, time.Until(deadline) <= responseURLDeadline},
+		{nil, reqCtx.Err()},
+	} {
+		test.Compare(t)
+	}
+	cancel()
+	Test{true, reqCtx.Err() != nil}.Compare(t)
+}
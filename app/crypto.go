@@ -0,0 +1,187 @@
+package app
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const encryptedTokenPrefix = "enc:v1:"
+
+// tokenCipher encrypts tokens with a random data key, which is then wrapped with the master key identified by KeyID
+type tokenCipher struct {
+	KeyID string
+	Keys  map[string][]byte
+}
+
+// parseTokenEncryptionKey parses comma separated "keyID:base64Key" pairs. The first key is used for encryption and the others are kept for decryption during rotation.
+func parseTokenEncryptionKey(value string) (*tokenCipher, error) {
+	c := &tokenCipher{Keys: map[string][]byte{}}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pair := strings.SplitN(entry, ":", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, errors.New("TOKEN_ENCRYPTION_KEY must be formatted as keyID:base64Key")
+		}
+		key, err := base64.StdEncoding.DecodeString(pair[1])
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("Encryption key %s must be 32 bytes", pair[0])
+		}
+		if _, ok := c.Keys[pair[0]]; ok {
+			return nil, fmt.Errorf("Encryption key %s is duplicated", pair[0])
+		}
+		if c.KeyID == "" {
+			c.KeyID = pair[0]
+		}
+		c.Keys[pair[0]] = key
+	}
+	if c.KeyID == "" {
+		return nil, errors.New("TOKEN_ENCRYPTION_KEY has no keys")
+	}
+	return c, nil
+}
+
+func isEncryptedToken(value string) bool {
+	return strings.HasPrefix(value, encryptedTokenPrefix)
+}
+
+func getTokenKeyID(value string) string {
+	if !isEncryptedToken(value) {
+		return ""
+	}
+	return strings.SplitN(strings.TrimPrefix(value, encryptedTokenPrefix), ":", 2)[0]
+}
+
+func encryptWithKey(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func decryptWithKey(key, sealed, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("Encrypted token is too short")
+	}
+	nonce := sealed[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, sealed[gcm.NonceSize():], additionalData)
+}
+
+// Encrypt encrypts the token for the user
+func (c *tokenCipher) Encrypt(userID, plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	wrappedKey, err := encryptWithKey(c.Keys[c.KeyID], dataKey, []byte(c.KeyID))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := encryptWithKey(dataKey, []byte(plaintext), []byte(userID))
+	if err != nil {
+		return "", err
+	}
+	return encryptedTokenPrefix + c.KeyID + ":" +
+		base64.StdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt decrypts the token for the user. Values not encrypted are returned as they are.
+func (c *tokenCipher) Decrypt(userID, value string) (string, error) {
+	if !isEncryptedToken(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, encryptedTokenPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("Encrypted token is malformed")
+	}
+	key, ok := c.Keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("Encryption key %s is not configured", parts[0])
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := decryptWithKey(key, wrappedKey, []byte(parts[0]))
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := decryptWithKey(dataKey, ciphertext, []byte(userID))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (app *App) reencryptTokens() (int, error) {
+	if app.TokenCipher == nil {
+		return 0, errors.New("TOKEN_ENCRYPTION_KEY is not configured")
+	}
+	count := 0
+	for _, hashKey := range []string{app.SalesforceTokenStoreKey, app.SlackTokenStoreKey} {
+		values, err := app.Store.All(hashKey)
+		if err != nil {
+			return count, err
+		}
+		for userID, value := range values {
+			if getTokenKeyID(value) == app.TokenCipher.KeyID {
+				continue
+			}
+			token, err := app.TokenCipher.Decrypt(userID, value)
+			if err != nil {
+				return count, err
+			}
+			encrypted, err := app.TokenCipher.Encrypt(userID, token)
+			if err != nil {
+				return count, err
+			}
+			if err := app.Store.Set(hashKey, userID, encrypted); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ReencryptTokens encrypts stored plaintext tokens and tokens encrypted with rotated keys using the current key
+func ReencryptTokens() (int, error) {
+	app, err := new()
+	if err != nil {
+		return 0, err
+	}
+	return app.reencryptTokens()
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+var (
+	testEncryptionKey1 = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	testEncryptionKey2 = base64.StdEncoding.EncodeToString([]byte("abcdef0123456789abcdef0123456789"))
+)
+
+func TestParseTokenEncryptionKey(t *testing.T) {
+	c, err := parseTokenEncryptionKey("k2:" + testEncryptionKey2 + ", k1:" + testEncryptionKey1)
+	for _, test := range []Test{
+		{true, err == nil},
+		{"k2", c.KeyID},
+		{2, len(c.Keys)},
+	} {
+		test.Compare(t)
+	}
+	for _, test := range []struct {
+		value    string
+		expected string
+	}{
+		{"", "TOKEN_ENCRYPTION_KEY has no keys"},
+		{testEncryptionKey1, "TOKEN_ENCRYPTION_KEY must be formatted as keyID:base64Key"},
+		{"k1:" + base64.StdEncoding.EncodeToString([]byte("short")), "Encryption key k1 must be 32 bytes"},
+		{"k1:" + testEncryptionKey1 + ",k1:" + testEncryptionKey2, "Encryption key k1 is duplicated"},
+	} {
+		_, err := parseTokenEncryptionKey(test.value)
+		Test{test.expected, err.Error()}.Compare(t)
+	}
+}
+
+func TestEncryptAndDecryptToken(t *testing.T) {
+	c1, _ := parseTokenEncryptionKey("k1:" + testEncryptionKey1)
+	c2, _ := parseTokenEncryptionKey("k2:" + testEncryptionKey2 + ",k1:" + testEncryptionKey1)
+	encrypted, err := c1.Encrypt("FOO", "secret")
+	for _, test := range []Test{
+		{true, err == nil},
+		{0, strings.Index(encrypted, "enc:v1:k1:")},
+		{-1, strings.Index(encrypted, "secret")},
+		{"k1", getTokenKeyID(encrypted)},
+		{"", getTokenKeyID("secret")},
+	} {
+		test.Compare(t)
+	}
+	decrypted, err := c2.Decrypt("FOO", encrypted)
+	for _, test := range []Test{
+		{true, err == nil},
+		{"secret", decrypted},
+	} {
+		test.Compare(t)
+	}
+	_, err = c2.Decrypt("BAR", encrypted)
+	Test{true, err != nil}.Compare(t)
+	encrypted, _ = c2.Encrypt("FOO", "secret")
+	_, err = c1.Decrypt("FOO", encrypted)
+	Test{"Encryption key k2 is not configured", err.Error()}.Compare(t)
+	decrypted, err = c1.Decrypt("FOO", "plaintext")
+	for _, test := range []Test{
+		{true, err == nil},
+		{"plaintext", decrypted},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestReencryptTokens(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	count, err := app.reencryptTokens()
+	for _, test := range []Test{
+		{0, count},
+		{"TOKEN_ENCRYPTION_KEY is not configured", err.Error()},
+	} {
+		test.Compare(t)
+	}
+	app.TokenCipher, _ = parseTokenEncryptionKey("k1:" + testEncryptionKey1)
+	ctx := app.createContext(nil)
+	ctx.UserID = "FOO"
+	ctx.setSlackAccessToken("foo")
+	app.Store.Set(app.SlackTokenStoreKey, "BAR", "bar")
+	app.Store.Set(app.SalesforceTokenStoreKey, "BAR", `{"access_token":"bar"}`)
+	app.TokenCipher, _ = parseTokenEncryptionKey("k2:" + testEncryptionKey2 + ",k1:" + testEncryptionKey1)
+	count, err = app.reencryptTokens()
+	for _, test := range []Test{
+		{true, err == nil},
+		{3, count},
+	} {
+		test.Compare(t)
+	}
+	for _, hashKey := range []string{app.SlackTokenStoreKey, app.SalesforceTokenStoreKey} {
+		values, _ := app.Store.All(hashKey)
+		for _, value := range values {
+			Test{"k2", getTokenKeyID(value)}.Compare(t)
+		}
+	}
+	ctx = app.createContext(nil)
+	ctx.UserID = "BAR"
+	slackToken, _ := ctx.getSlackAccessTokenForUser()
+	salesforceToken, _ := ctx.getSalesforceAccessTokenForUser()
+	for _, test := range []Test{
+		{"bar", slackToken},
+		{"bar", salesforceToken.AccessToken},
+	} {
+		test.Compare(t)
+	}
+	count, _ = app.reencryptTokens()
+	Test{0, count}.Compare(t)
+}
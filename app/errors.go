@@ -0,0 +1,68 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var (
+	// errUnauthorized is returned when the user has no valid Salesforce token
+	errUnauthorized = errors.New("Unauthorized")
+	// errRefreshTokenRevoked is returned when Salesforce refuses to refresh the access token
+	errRefreshTokenRevoked = errors.New("Refresh token is revoked or expired")
+	// errTimeout is returned when TeamSpirit does not respond in time
+	errTimeout = errors.New("Request timed out")
+	// errConflict is returned when the time table is modified after it was fetched
+	errConflict = errors.New("Time table is modified")
+)
+
+// storeError is returned when the store fails, which must not be mistaken for missing values
+type storeError struct {
+	Err error
+}
+
+func (err *storeError) Error() string {
+	return fmt.Sprintf("Store Error: %+v", err.Err)
+}
+
+const (
+	invalidSessionIDErrorCode = "INVALID_SESSION_ID"
+	// httpErrorCode is the code of non-2xx responses without Salesforce REST API errors
+	httpErrorCode = "HTTP_ERROR"
+)
+
+func (err *timeTableError) Error() string {
+	return fmt.Sprintf("Error: %+v (%+v)", err.Message, err.Code)
+}
+
+// isUnauthorizedError returns true if the user needs to authenticate again
+func isUnauthorizedError(err error) bool {
+	if err == errUnauthorized || err == errRefreshTokenRevoked {
+		return true
+	}
+	if apiErr, ok := err.(*timeTableError); ok {
+		return apiErr.Code == invalidSessionIDErrorCode
+	}
+	return false
+}
+
+// classifyRequestError converts errors from the OAuth2 HTTP client into the typed errors
+func classifyRequestError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == context.DeadlineExceeded {
+		return errTimeout
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return errTimeout
+	}
+	// golang.org/x/oauth2 returns the token endpoint response as a string
+	if strings.Contains(err.Error(), "invalid_grant") {
+		return errRefreshTokenRevoked
+	}
+	return err
+}
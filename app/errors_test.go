@@ -0,0 +1,45 @@
+package app
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (err *timeoutError) Error() string   { return "i/o timeout" }
+func (err *timeoutError) Timeout() bool   { return true }
+func (err *timeoutError) Temporary() bool { return true }
+
+func TestTimeTableErrorString(t *testing.T) {
+	err := &timeTableError{Message: "Session expired or invalid", Code: "INVALID_SESSION_ID"}
+	Test{"Error: Session expired or invalid (INVALID_SESSION_ID)", err.Error()}.Compare(t)
+}
+
+func TestIsUnauthorizedError(t *testing.T) {
+	for _, test := range []Test{
+		{true, isUnauthorizedError(errUnauthorized)},
+		{true, isUnauthorizedError(errRefreshTokenRevoked)},
+		{true, isUnauthorizedError(&timeTableError{Code: "INVALID_SESSION_ID"})},
+		{false, isUnauthorizedError(&timeTableError{Code: "APEX_ERROR"})},
+		{false, isUnauthorizedError(errTimeout)},
+		{false, isUnauthorizedError(errors.New("foo"))},
+		{false, isUnauthorizedError(nil)},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestClassifyRequestError(t *testing.T) {
+	foo := errors.New("foo")
+	for _, test := range []Test{
+		{nil, classifyRequestError(nil)},
+		{foo, classifyRequestError(foo)},
+		{errTimeout, classifyRequestError(&url.Error{Op: "Get", URL: "https://example.com", Err: &timeoutError{}})},
+		{errRefreshTokenRevoked, classifyRequestError(errors.New("oauth2: cannot fetch token: 400 Bad Request\nResponse: {\"error\":\"invalid_grant\",\"error_description\":\"expired access/refresh token\"}"))},
+		{errRefreshTokenRevoked, classifyRequestError(&url.Error{Op: "Get", URL: "https://example.com", Err: errors.New("invalid_grant")})},
+	} {
+		test.Compare(t)
+	}
+}
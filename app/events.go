@@ -0,0 +1,258 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+const (
+	eventTypeURLVerification = "url_verification"
+	eventTypeCallback        = "event_callback"
+	// eventTTL is how long received event IDs are kept to ignore the retries of Slack
+	eventTTL = 10 * time.Minute
+)
+
+// eventPayload is the request body of the Events API
+type eventPayload struct {
+	Token     string     `json:"token"`
+	Type      string     `json:"type"`
+	Challenge string     `json:"challenge"`
+	TeamID    string     `json:"team_id"`
+	EventID   string     `json:"event_id"`
+	Event     slackEvent `json:"event"`
+}
+
+// slackEvent is the event in the payload, holding the fields of the dispatched event types
+type slackEvent struct {
+	Type        string    `json:"type"`
+	Subtype     string    `json:"subtype"`
+	User        eventUser `json:"user"`
+	BotID       string    `json:"bot_id"`
+	Channel     string    `json:"channel"`
+	ChannelType string    `json:"channel_type"`
+	Text        string    `json:"text"`
+	Tab         string    `json:"tab"`
+	Tokens      struct {
+		OAuth []string `json:"oauth"`
+	} `json:"tokens"`
+}
+
+// eventUser is the ID of the user of the event, which user_change events give as the user object
+type eventUser string
+
+func (user *eventUser) UnmarshalJSON(b []byte) error {
+	var id string
+	if err := json.Unmarshal(b, &id); err == nil {
+		*user = eventUser(id)
+		return nil
+	}
+	var object struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(b, &object); err != nil {
+		return err
+	}
+	*user = eventUser(object.ID)
+	return nil
+}
+
+// name returns the event type with the channel type for messages, as subscribed in the Slack app settings
+func (event *slackEvent) name() string {
+	if event.Type == "message" {
+		return event.Type + "." + event.ChannelType
+	}
+	return event.Type
+}
+
+// eventHandlers are the handlers of the subscribed events
+var eventHandlers = map[string]func(ctx *Context, event *slackEvent) error{
+	"app_home_opened": (*Context).handleAppHomeOpened,
+	"app_mention":     (*Context).handleMessageEvent,
+	"message.im":      (*Context).handleMessageEvent,
+	"tokens_revoked":  (*Context).handleTokensRevoked,
+	"app_uninstalled": (*Context).handleAppUninstalled,
+	"user_change":     (*Context).handleUserChange,
+}
+
+func (app *App) handleEvent(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var payload eventPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if app.SlackSigningSecret == "" && payload.Token != app.SlackVerificationToken {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	if payload.Type == eventTypeURLVerification {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(payload.Challenge))
+		return
+	}
+	ctx := app.createContext(r)
+	ctx.TeamID = payload.TeamID
+	ctx.UserID = string(payload.Event.User)
+	handler := eventHandlers[payload.Event.name()]
+	if payload.Type != eventTypeCallback || handler == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	received, err := ctx.receiveEvent(payload.EventID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if received {
+		go func() {
+			if err := handler(ctx, &payload.Event); err != nil {
+				fmt.Printf("Handle Event Error: %+v\n", err.Error())
+			}
+		}()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// receiveEvent records the event ID and returns false if the event was already received, even by another process
+func (ctx *Context) receiveEvent(eventID string) (bool, error) {
+	expiresAt := ctx.now().Add(eventTTL).Unix()
+	return ctx.Store.SetIfAbsent(ctx.EventStoreKey, eventID, strconv.FormatInt(expiresAt, 10))
+}
+
+// purgeExpiredEvents deletes the event IDs older than eventTTL, which Slack no longer retries
+func (ctx *Context) purgeExpiredEvents() error {
+	events, err := ctx.Store.All(ctx.EventStoreKey)
+	if err != nil {
+		return err
+	}
+	now := ctx.now().Unix()
+	for eventID, value := range events {
+		if expiresAt, err := strconv.ParseInt(value, 10, 64); err == nil && now < expiresAt {
+			continue
+		}
+		if err := ctx.Store.Delete(ctx.EventStoreKey, eventID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleAppHomeOpened publishes the usage to the Home tab
+func (ctx *Context) handleAppHomeOpened(event *slackEvent) error {
+	if event.Tab != "home" || ctx.SlackBotToken == "" {
+		return nil
+	}
+	msg, err := ctx.getHelpSlackMessage(&commandInput{})
+	if err != nil {
+		return err
+	}
+	return publishSlackHomeView(ctx.SlackBotToken, ctx.UserID, msg.Blocks)
+}
+
+var mentionPattern = regexp.MustCompile(`<@[0-9A-Z]+>`)
+
+// handleMessageEvent runs the mention or the DM to the bot as the subcommand of /ts and replies by DM
+func (ctx *Context) handleMessageEvent(event *slackEvent) error {
+	if event.BotID != "" || event.Subtype != "" || event.User == "" {
+		return nil
+	}
+	text := strings.TrimSpace(mentionPattern.ReplaceAllString(event.Text, ""))
+	text = strings.TrimSpace(strings.TrimPrefix(text, "/ts"))
+	msg, err := ctx.getSlackMessage(slack.SlashCommand{
+		TeamID: ctx.TeamID,
+		UserID: ctx.UserID,
+		Text:   text,
+	})
+	if err != nil && msg == nil {
+		return err
+	}
+	return ctx.sendDirectMessage(msg)
+}
+
+// invalidSlackTokenErrors are the errors of the Slack API for the tokens which are no longer usable
+var invalidSlackTokenErrors = map[string]bool{
+	"invalid_auth":     true,
+	"token_revoked":    true,
+	"account_inactive": true,
+}
+
+// forgetSlackToken deletes the Slack token of the user with the notify channel which is posted with it
+func (ctx *Context) forgetSlackToken() error {
+	for _, hashKey := range []string{ctx.SlackTokenStoreKey, ctx.NotifyChannelStoreKey} {
+		if err := ctx.deleteVariableInHash(hashKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleTokensRevoked forgets the Slack tokens of the users who revoked them
+func (ctx *Context) handleTokensRevoked(event *slackEvent) error {
+	for _, userID := range event.Tokens.OAuth {
+		ctx.UserID = userID
+		if err := ctx.forgetSlackToken(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleAppUninstalled stops the DMs to the users of the team and forgets their Slack tokens
+func (ctx *Context) handleAppUninstalled(event *slackEvent) error {
+	for _, hashKey := range []string{ctx.ReminderStoreKey, ctx.PresenceStoreKey} {
+		values, err := ctx.Store.All(hashKey)
+		if err != nil {
+			return err
+		}
+		for userID, value := range values {
+			var setting struct {
+				TeamID string `json:"teamId"`
+			}
+			if json.Unmarshal([]byte(value), &setting) != nil || setting.TeamID != ctx.TeamID {
+				continue
+			}
+			if err := ctx.Store.Delete(hashKey, userID); err != nil {
+				return err
+			}
+		}
+	}
+	// the Slack tokens are stored without the team, which auth.test tells unless they are revoked already
+	tokens, err := ctx.Store.All(ctx.SlackTokenStoreKey)
+	if err != nil {
+		return err
+	}
+	for userID := range tokens {
+		ctx.UserID = userID
+		token, err := ctx.getSlackAccessTokenForUser()
+		if err != nil {
+			return err
+		}
+		if token == "" {
+			continue
+		}
+		res, err := slack.New(token).AuthTest()
+		if err != nil && !invalidSlackTokenErrors[err.Error()] {
+			// the token is kept if Slack does not tell it is invalid
+			continue
+		}
+		if err == nil && res.TeamID != ctx.TeamID {
+			continue
+		}
+		if err := ctx.forgetSlackToken(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func createEventRequest(payload map[string]interface{}) *http.Request {
+	b, _ := json.Marshal(payload)
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/events", strings.NewReader(string(b)))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestHandleEventURLVerification(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	for _, test := range []struct {
+		token string
+		code  int
+		body  string
+	}{
+		{app.SlackVerificationToken, 200, "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"},
+		{"invalid", 401, "Invalid token\n"},
+	} {
+		res := httptest.NewRecorder()
+		req := createEventRequest(map[string]interface{}{
+			"token":     test.token,
+			"type":      eventTypeURLVerification,
+			"challenge": "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P",
+		})
+		app.setupRouter().ServeHTTP(res, req)
+		for _, test := range []Test{
+			{test.code, res.Code},
+			{test.body, res.Body.String()},
+		} {
+			test.Compare(t)
+		}
+	}
+}
+
+func TestHandleEventSigned(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	app.SlackSigningSecret = "secret"
+	payload := map[string]interface{}{
+		"type":      eventTypeURLVerification,
+		"challenge": "foo",
+	}
+	b, _ := json.Marshal(payload)
+
+	res := httptest.NewRecorder()
+	app.setupRouter().ServeHTTP(res, createEventRequest(payload))
+	Test{401, res.Code}.Compare(t)
+
+	res = httptest.NewRecorder()
+	req := createEventRequest(payload)
+	signSlackRequest(req, "secret", string(b), time.Now())
+	app.setupRouter().ServeHTTP(res, req)
+	for _, test := range []Test{
+		{200, res.Code},
+		{"foo", res.Body.String()},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestHandleEventDeduplicated(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	app.SlackBotToken = "xoxb-test"
+	setupDirectMessageGock("", "xoxb-test")
+	payload := map[string]interface{}{
+		"token":    app.SlackVerificationToken,
+		"type":     eventTypeCallback,
+		"team_id":  "T12345678",
+		"event_id": "Ev12345678",
+		"event": map[string]interface{}{
+			"type":         "message",
+			"channel_type": "im",
+			"user":         "FOO",
+			"text":         "help",
+		},
+	}
+	for i := 0; i < 2; i++ {
+		res := httptest.NewRecorder()
+		app.setupRouter().ServeHTTP(res, createEventRequest(payload))
+		time.Sleep(100 * time.Millisecond)
+		Test{200, res.Code}.Compare(t)
+	}
+	for _, test := range []Test{
+		{true, gock.IsDone()},
+		{false, gock.HasUnmatchedRequest()},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestReceiveEvent(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	ctx := app.createUserContext("", "", getMockTime())
+	later := app.createUserContext("", "", getMockTime().Add(eventTTL))
+	for _, test := range []Test{
+		{true, isReceived(ctx.receiveEvent("Ev1"))},
+		{false, isReceived(ctx.receiveEvent("Ev1"))},
+		{true, isReceived(ctx.receiveEvent("Ev2"))},
+		{false, isReceived(later.receiveEvent("Ev1"))},
+		{nil, later.purgeExpiredEvents()},
+		{true, isReceived(later.receiveEvent("Ev1"))},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestReceiveEventConcurrently(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	ctx := app.createUserContext("", "", getMockTime())
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	received := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if isReceived(ctx.receiveEvent("Ev1")) {
+				mutex.Lock()
+				received++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	Test{1, received}.Compare(t)
+}
+
+func isReceived(received bool, err error) bool {
+	return received && err == nil
+}
+
+func TestHandleMessageEvent(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := app.createUserContext("T12345678", "FOO", getMockTime())
+	ctx.SlackBotToken = "xoxb-test"
+	setupDirectMessageGock("%60%2Fts\\+tz", "xoxb-test")
+	for _, test := range []Test{
+		{nil, ctx.handleMessageEvent(&slackEvent{Type: "app_mention", User: "FOO", Text: "<@U0LAN0Z89> help tz"})},
+		{true, gock.IsDone()},
+		{nil, ctx.handleMessageEvent(&slackEvent{Type: "message", ChannelType: "im", BotID: "B12345678", Text: "help"})},
+		{nil, ctx.handleMessageEvent(&slackEvent{Type: "message", ChannelType: "im", Subtype: "message_changed", Text: "help"})},
+		{false, gock.HasUnmatchedRequest()},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestHandleAppHomeOpened(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := app.createUserContext("T12345678", "FOO", getMockTime())
+	Test{nil, ctx.handleAppHomeOpened(&slackEvent{Type: "app_home_opened", Tab: "home"})}.Compare(t)
+
+	ctx.SlackBotToken = "xoxb-test"
+	gock.New("https://slack.com").
+		Post("/api/views.publish").
+		BodyString("token=xoxb-test&user_id=FOO&view=.*home").
+		Reply(200).
+		JSON(map[string]interface{}{"ok": true})
+	for _, test := range []Test{
+		{nil, ctx.handleAppHomeOpened(&slackEvent{Type: "app_home_opened", Tab: "messages"})},
+		{nil, ctx.handleAppHomeOpened(&slackEvent{Type: "app_home_opened", Tab: "home"})},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestHandleTokensRevoked(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	for _, userID := range []string{"FOO", "BAR", "BAZ"} {
+		ctx := app.createUserContext("T12345678", userID, getMockTime())
+		ctx.setSlackAccessToken("token-" + userID)
+	}
+	ctx := app.createUserContext("T12345678", "", getMockTime())
+	event := &slackEvent{Type: "tokens_revoked"}
+	event.Tokens.OAuth = []string{"FOO", "BAR"}
+	Test{nil, ctx.handleTokensRevoked(event)}.Compare(t)
+	for _, test := range []struct {
+		userID   string
+		expected string
+	}{
+		{"FOO", ""},
+		{"BAR", ""},
+		{"BAZ", "token-BAZ"},
+	} {
+		ctx := app.createUserContext("T12345678", test.userID, getMockTime())
+		token, _ := ctx.getSlackAccessTokenForUser()
+		Test{test.expected, token}.Compare(t)
+	}
+}
+
+func TestHandleAppUninstalled(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	foo := app.createUserContext("", "FOO", getMockTime())
+	foo.setReminder(&reminder{TeamID: "T12345678", Minutes: 570})
+	foo.setPresenceWatch(&presenceWatch{TeamID: "T12345678"})
+	bar := app.createUserContext("", "BAR", getMockTime())
+	bar.setReminder(&reminder{TeamID: "T87654321", Minutes: 570})
+
+	for userID, res := range map[string]map[string]interface{}{
+		"FOO": {"ok": true, "team_id": "T12345678", "user_id": "FOO"},
+		"BAR": {"ok": true, "team_id": "T87654321", "user_id": "BAR"},
+		"BAZ": {"ok": false, "error": "token_revoked"},
+		"QUX": {"ok": false, "error": "ratelimited"},
+	} {
+		ctx := app.createUserContext("", userID, getMockTime())
+		ctx.setSlackAccessToken("token-" + userID)
+		ctx.setVariableInHash(ctx.NotifyChannelStoreKey, "C1234567")
+		gock.New("https://slack.com").
+			Post("/api/auth.test").
+			BodyString("token=token-" + userID).
+			Reply(200).
+			JSON(res)
+	}
+
+	ctx := app.createUserContext("T12345678", "", getMockTime())
+	for _, test := range []Test{
+		{nil, ctx.handleAppUninstalled(&slackEvent{Type: "app_uninstalled"})},
+		{true, foo.getReminderForUser() == nil},
+		{true, foo.getPresenceWatchForUser() == nil},
+		{"T87654321", bar.getReminderForUser().TeamID},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+	for _, test := range []struct {
+		userID   string
+		expected string
+	}{
+		{"FOO", ""},
+		{"BAR", "token-BAR"},
+		{"BAZ", ""},
+		{"QUX", "token-QUX"},
+	} {
+		ctx := app.createUserContext("", test.userID, getMockTime())
+		token, _ := ctx.getSlackAccessTokenForUser()
+		channel, _ := ctx.getSlackNotifyChannelForUser()
+		Test{test.expected, token}.Compare(t)
+		Test{test.expected != "", channel != ""}.Compare(t)
+	}
+}
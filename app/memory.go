@@ -0,0 +1,70 @@
+package app
+
+import "sync"
+
+type memoryStore struct {
+	mutex  sync.RWMutex
+	hashes map[string]map[string]string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{hashes: map[string]map[string]string{}}
+}
+
+func (s *memoryStore) Get(hashKey, field string) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.hashes[hashKey][field], nil
+}
+
+func (s *memoryStore) Set(hashKey, field, value string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	hash, ok := s.hashes[hashKey]
+	if !ok {
+		hash = map[string]string{}
+		s.hashes[hashKey] = hash
+	}
+	hash[field] = value
+	return nil
+}
+
+func (s *memoryStore) SetIfAbsent(hashKey, field, value string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	hash, ok := s.hashes[hashKey]
+	if !ok {
+		hash = map[string]string{}
+		s.hashes[hashKey] = hash
+	}
+	if _, ok := hash[field]; ok {
+		return false, nil
+	}
+	hash[field] = value
+	return true, nil
+}
+
+func (s *memoryStore) Delete(hashKey, field string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.hashes[hashKey], field)
+	return nil
+}
+
+func (s *memoryStore) Take(hashKey, field string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	value := s.hashes[hashKey][field]
+	delete(s.hashes[hashKey], field)
+	return value, nil
+}
+
+func (s *memoryStore) All(hashKey string) (map[string]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	values := map[string]string{}
+	for field, value := range s.hashes[hashKey] {
+		values[field] = value
+	}
+	return values, nil
+}
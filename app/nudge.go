@@ -0,0 +1,122 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+const (
+	// defaultLeaveNudgeAfter is how long after the standard end time a user still clocked in is nudged
+	defaultLeaveNudgeAfter = 2 * time.Hour
+	// leaveNudgeCheckInterval is how often the time table of a user is checked while the due time is unknown or not attending after it
+	leaveNudgeCheckInterval = 30 * time.Minute
+	// leaveNudgeRepeatInterval is how long after a nudge the user is nudged again if still clocked in
+	leaveNudgeRepeatInterval = time.Hour
+	// leaveNudgeSnoozeDuration is how long nudges are postponed when the user is still working
+	leaveNudgeSnoozeDuration = 2 * time.Hour
+)
+
+// leaveNudge is the state of the forgot-to-clock-out nudges of a user
+type leaveNudge struct {
+	// NextCheckAt is the unix time when the time table is checked next
+	NextCheckAt int64 `json:"nextCheckAt"`
+}
+
+func (ctx *Context) getLeaveNudgeForUser() *leaveNudge {
+	value, _ := ctx.getVariableInHash(ctx.LeaveNudgeStoreKey, ctx.UserID)
+	var nudge leaveNudge
+	if value == "" || json.Unmarshal([]byte(value), &nudge) != nil {
+		return &leaveNudge{}
+	}
+	return &nudge
+}
+
+// setNextLeaveNudgeCheck postpones the next check of the user after the duration from now
+func (ctx *Context) setNextLeaveNudgeCheck(d time.Duration) error {
+	b, err := json.Marshal(&leaveNudge{NextCheckAt: ctx.now().Add(d).Unix()})
+	if err != nil {
+		return err
+	}
+	return ctx.setVariableInHash(ctx.LeaveNudgeStoreKey, string(b))
+}
+
+// sendLeaveNudges nudges the users who are still clocked in long after the standard end time
+func (app *App) sendLeaveNudges(now time.Time) {
+	tokens, err := app.Store.All(app.SalesforceTokenStoreKey)
+	if err != nil {
+		fmt.Printf("Load Tokens Error: %+v\n", err.Error())
+		return
+	}
+	forEachUser(tokens, func(userID string) {
+		ctx := app.createUserContext("", userID, now)
+		if err := ctx.sendLeaveNudge(); err != nil {
+			fmt.Printf("Send Leave Nudge Error: %+v\n", err.Error())
+		}
+	})
+}
+
+// sendLeaveNudge sends a DM with the leave button if the user is still clocked in after the standard end time plus LeaveNudgeAfter
+func (ctx *Context) sendLeaveNudge() error {
+	if token, err := ctx.getSlackAPIToken(); err != nil || token == "" {
+		return err
+	}
+	if ctx.now().Unix() < ctx.getLeaveNudgeForUser().NextCheckAt {
+		return nil
+	}
+	reqCtx, cancel := ctx.newRequestContext()
+	defer cancel()
+	client, err := ctx.createTimeTableClient(reqCtx)
+	if err != nil {
+		return err
+	}
+	now := ctx.localNow()
+	tt, err := client.GetCurrentTimeTable(reqCtx, now)
+	if err != nil {
+		ctx.setNextLeaveNudgeCheck(leaveNudgeCheckInterval)
+		return err
+	}
+	if tt.IsLeaving() {
+		return ctx.setNextLeaveNudgeCheck(ctx.nextMorning().Sub(now))
+	}
+	if tt.StdEndTime == nil {
+		return ctx.setNextLeaveNudgeCheck(leaveNudgeCheckInterval)
+	}
+	due := *tt.StdEndTime + int64(ctx.LeaveNudgeAfter/time.Minute)
+	if minutes := tt.convertTime(now).Int64; minutes < due {
+		return ctx.setNextLeaveNudgeCheck(time.Duration(due-minutes) * time.Minute)
+	}
+	if !tt.IsAttending() {
+		return ctx.setNextLeaveNudgeCheck(leaveNudgeCheckInterval)
+	}
+	if err := ctx.setNextLeaveNudgeCheck(leaveNudgeRepeatInterval); err != nil {
+		return err
+	}
+	return ctx.sendDirectMessage(getLeaveNudgeDirectMessage(*tt.StdEndTime))
+}
+
+func getLeaveNudgeDirectMessage(stdEndTime int64) *message {
+	text := "定時 (" + formatMinutes(stdEndTime) + ") を過ぎていますが、まだ退勤の打刻がされていません :crescent_moon:"
+	leave := buttonElement(actionTypeLeave, "退勤する", "danger")
+	leave.Confirm = confirmDialog("退勤", "退勤しますか？", "はい", "いいえ")
+	return &message{
+		Msg: slack.Msg{Text: text},
+		Blocks: []block{
+			sectionBlock(text),
+			actionsBlock(callbackIDLeaveNudge,
+				leave,
+				buttonElement(actionTypeSnoozeLeave, "まだ働いています", ""),
+			),
+		},
+	}
+}
+
+// snoozeLeaveNudge postpones the nudges of the user and returns the reply to the snooze action
+func (ctx *Context) snoozeLeaveNudge() (string, error) {
+	if err := ctx.setNextLeaveNudgeCheck(leaveNudgeSnoozeDuration); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("お疲れさまです。%d 時間後にもう一度確認します :muscle:", leaveNudgeSnoozeDuration/time.Hour), nil
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	null "gopkg.in/guregu/null.v3"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func setupLeaveNudgeGocks(items []timeTableItem, stdEndTime int64) {
+	gock.New("https://teamspirit-1234.cloudforce.test").
+		Get("/services/apexrest/Dakoku").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"timeTable":  items,
+			"stdEndTime": stdEndTime,
+		})
+}
+
+func TestSendLeaveNudges(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := createLoggedInTestContext(app, getMockTime())
+	attending := []timeTableItem{{From: null.IntFrom(420), Type: 1}}
+
+	// users who clocked in outside ts-dakoku are nudged too
+	setupLeaveNudgeGocks(attending, 480)
+	setupDirectMessageGock("", "baz")
+	app.sendLeaveNudges(getMockTime())
+	for _, test := range []Test{
+		{true, gock.IsDone()},
+		{getMockTime().Add(leaveNudgeRepeatInterval).Unix(), ctx.getLeaveNudgeForUser().NextCheckAt},
+	} {
+		test.Compare(t)
+	}
+
+	// not checked again until NextCheckAt
+	app.sendLeaveNudges(getMockTime().Add(time.Minute))
+	Test{getMockTime().Add(leaveNudgeRepeatInterval).Unix(), ctx.getLeaveNudgeForUser().NextCheckAt}.Compare(t)
+}
+
+func TestSendLeaveNudgeNotDue(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	for _, test := range []struct {
+		items      []timeTableItem
+		stdEndTime int64
+		wait       time.Duration
+	}{
+		{[]timeTableItem{{From: null.IntFrom(420), Type: 1}}, 1080, 528 * time.Minute},
+		{[]timeTableItem{{From: null.IntFrom(420), Type: 1}}, 560, 8 * time.Minute},
+		{[]timeTableItem{}, 1080, 528 * time.Minute},
+		{[]timeTableItem{}, 480, leaveNudgeCheckInterval},
+		// checked again at 6:00 of the next day
+		{[]timeTableItem{{From: null.IntFrom(420), To: null.IntFrom(600), Type: 1}}, 480, 18*time.Hour + 47*time.Minute + 38*time.Second},
+	} {
+		app.CleanStore()
+		ctx := createLoggedInTestContext(app, getMockTime())
+		setupLeaveNudgeGocks(test.items, test.stdEndTime)
+		for _, test := range []Test{
+			{nil, ctx.sendLeaveNudge()},
+			{true, gock.IsDone()},
+			{getMockTime().Add(test.wait).Unix(), ctx.getLeaveNudgeForUser().NextCheckAt},
+		} {
+			test.Compare(t)
+		}
+	}
+}
+
+func TestSendLeaveNudgeWithoutSlack(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	ctx := app.createUserContext("", "FOO", getMockTime())
+	for _, test := range []Test{
+		{nil, ctx.sendLeaveNudge()},
+		{int64(0), ctx.getLeaveNudgeForUser().NextCheckAt},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestGetLeaveNudgeDirectMessage(t *testing.T) {
+	msg := getLeaveNudgeDirectMessage(1080)
+	actions := msg.getActions()
+	for _, test := range []Test{
+		{"定時 (18:00) を過ぎていますが、まだ退勤の打刻がされていません :crescent_moon:", msg.Text},
+		{callbackIDLeaveNudge, msg.Blocks[1].BlockID},
+		{actionTypeLeave, actions[0].ActionID},
+		{actionTypeSnoozeLeave, actions[1].ActionID},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestHandleSnoozeLeaveAction(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	gock.New("https://hooks.slack.test").
+		Post("/coolhook").
+		Reply(200)
+	res := httptest.NewRecorder()
+	req := createBlockActionCallbackRequest(callbackIDLeaveNudge, actionTypeSnoozeLeave, app.SlackVerificationToken)
+	app.setupRouter().ServeHTTP(res, req)
+	time.Sleep(100 * time.Millisecond)
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	for _, test := range []Test{
+		{200, res.Code},
+		{"お疲れさまです。2 時間後にもう一度確認します :muscle:", res.Body.String()},
+		{true, ctx.getLeaveNudgeForUser().NextCheckAt > time.Now().Add(time.Hour).Unix()},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+}
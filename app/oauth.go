@@ -4,12 +4,22 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
 	"golang.org/x/oauth2"
 )
 
+const defaultSalesforceLoginHost = "login.salesforce.com"
+
+type salesforceToken struct {
+	oauth2.Token
+	InstanceURL string `json:"instance_url,omitempty"`
+	LoginHost   string `json:"login_host,omitempty"`
+}
+
 func (ctx *Context) getSalesforceOAuthCallbackURL() string {
 	return "https://" + ctx.Request.Host + "/oauth/salesforce/callback"
 }
@@ -26,7 +36,28 @@ func (ctx *Context) getSlackAuthenticateURL(teamID, state string) string {
 	return "https://" + ctx.Request.Host + "/oauth/slack/authenticate/" + teamID + "/" + state
 }
 
+func (ctx *Context) getSalesforceLoginHost() string {
+	if host := ctx.SalesforceLoginHosts[ctx.TeamID]; host != "" {
+		return host
+	}
+	if ctx.SalesforceLoginHost != "" {
+		return ctx.SalesforceLoginHost
+	}
+	return defaultSalesforceLoginHost
+}
+
 func (ctx *Context) setSalesforceAccessToken(token *oauth2.Token) error {
+	stored := &salesforceToken{
+		Token:     *token,
+		LoginHost: ctx.getSalesforceLoginHost(),
+	}
+	if instanceURL, ok := token.Extra("instance_url").(string); ok {
+		stored.InstanceURL = instanceURL
+	}
+	return ctx.setSalesforceToken(stored)
+}
+
+func (ctx *Context) setSalesforceToken(token *salesforceToken) error {
 	if ctx.UserID == "" {
 		return errors.New("UserID is not set")
 	}
@@ -38,47 +69,67 @@ func (ctx *Context) setSalesforceAccessToken(token *oauth2.Token) error {
 	if err != nil {
 		return err
 	}
-	return ctx.setVariableInHash(ctx.SalesforceTokenStoreKey, tokenJSON)
+	return ctx.setTokenInHash(ctx.SalesforceTokenStoreKey, string(tokenJSON))
 }
 
 func (ctx *Context) setSlackAccessToken(token string) error {
 	if ctx.UserID == "" {
 		return errors.New("UserID is not set")
 	}
-	return ctx.setVariableInHash(ctx.SlackTokenStoreKey, token)
+	return ctx.setTokenInHash(ctx.SlackTokenStoreKey, token)
 }
 
 func (ctx *Context) getSalesforceOAuth2Config() *oauth2.Config {
+	return ctx.getSalesforceOAuth2ConfigForHost(ctx.getSalesforceLoginHost())
+}
+
+func (ctx *Context) getSalesforceOAuth2ConfigForHost(loginHost string) *oauth2.Config {
+	redirectURL := ""
+	if ctx.Request != nil {
+		redirectURL = ctx.getSalesforceOAuthCallbackURL()
+	}
 	return &oauth2.Config{
 		ClientID:     ctx.SalesforceClientID,
 		ClientSecret: ctx.SalesforceClientSecret,
 		Scopes:       []string{},
-		RedirectURL:  ctx.getSalesforceOAuthCallbackURL(),
+		RedirectURL:  redirectURL,
 		Endpoint: oauth2.Endpoint{
 			// https://developer.salesforce.com/docs/atlas.en-us.api_rest.meta/api_rest/intro_understanding_oauth_endpoints.htm
-			AuthURL:  "https://login.salesforce.com/services/oauth2/authorize",
-			TokenURL: "https://login.salesforce.com/services/oauth2/token",
+			AuthURL:  "https://" + loginHost + "/services/oauth2/authorize",
+			TokenURL: "https://" + loginHost + "/services/oauth2/token",
 		},
 	}
 }
 
-func (ctx *Context) getSalesforceAccessTokenForUser() *oauth2.Token {
+// getSalesforceTokenForUser returns the token of the user, or nil if the user has not logged in
+func (ctx *Context) getSalesforceTokenForUser() (*salesforceToken, error) {
 	if ctx.UserID == "" {
-		return nil
+		return nil, nil
 	}
-	tokenJSON := ctx.getVariableInHash(ctx.SalesforceTokenStoreKey, ctx.UserID)
-	var token oauth2.Token
+	tokenJSON, err := ctx.getTokenInHash(ctx.SalesforceTokenStoreKey)
+	if err != nil {
+		return nil, err
+	}
+	var token salesforceToken
 	if err := json.Unmarshal([]byte(tokenJSON), &token); err != nil {
-		return nil
+		return nil, nil
 	}
-	return &token
+	return &token, nil
 }
 
-func (ctx *Context) getSlackAccessTokenForUser() string {
-	return ctx.getVariableInHash(ctx.SlackTokenStoreKey, ctx.UserID)
+func (ctx *Context) getSalesforceAccessTokenForUser() (*oauth2.Token, error) {
+	token, err := ctx.getSalesforceTokenForUser()
+	if token == nil {
+		return nil, err
+	}
+	return &token.Token, nil
 }
 
-func (ctx *Context) getSlackNotifyChannelForUser() string {
+func (ctx *Context) getSlackAccessTokenForUser() (string, error) {
+	return ctx.getTokenInHash(ctx.SlackTokenStoreKey)
+}
+
+func (ctx *Context) getSlackNotifyChannelForUser() (string, error) {
 	return ctx.getVariableInHash(ctx.NotifyChannelStoreKey, ctx.UserID)
 }
 
@@ -91,15 +142,111 @@ func (ctx *Context) getSalesforceAccessToken(code, state string) (*oauth2.Token,
 	return t, nil
 }
 
-func (ctx *Context) getSalesforceOAuth2Client() *http.Client {
-	token := ctx.getSalesforceAccessTokenForUser()
-	if token == nil {
-		return nil
+// getSalesforceOAuth2Client returns the client which refreshes the token of the user within TeamSpiritTimeout
+func (ctx *Context) getSalesforceOAuth2Client(reqCtx context.Context) (*http.Client, error) {
+	stored, err := ctx.getSalesforceTokenForUser()
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, errUnauthorized
+	}
+	loginHost := stored.LoginHost
+	if loginHost == "" {
+		loginHost = ctx.getSalesforceLoginHost()
 	}
-	src := ctx.getSalesforceOAuth2Config().TokenSource(context.TODO(), token)
+	token := &stored.Token
+	// the token endpoint is requested with the client in the context, which is http.DefaultClient without timeout by default
+	reqCtx = context.WithValue(reqCtx, oauth2.HTTPClient, &http.Client{Timeout: ctx.TeamSpiritTimeout})
+	src := ctx.getSalesforceOAuth2ConfigForHost(loginHost).TokenSource(reqCtx, token)
 	ts := oauth2.ReuseTokenSource(token, src)
-	if token, _ := ts.Token(); token != nil {
-		ctx.setSalesforceAccessToken(token)
+	token, err = ts.Token()
+	if err = classifyRequestError(err); err == errRefreshTokenRevoked {
+		ctx.deleteVariableInHash(ctx.SalesforceTokenStoreKey)
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+	if token.AccessToken == stored.AccessToken {
+		return oauth2.NewClient(reqCtx, ts), nil
+	}
+	refreshed := &salesforceToken{
+		Token:       *token,
+		InstanceURL: stored.InstanceURL,
+		LoginHost:   loginHost,
+	}
+	if instanceURL, ok := token.Extra("instance_url").(string); ok {
+		refreshed.InstanceURL = instanceURL
+	}
+	ctx.setSalesforceToken(refreshed)
+	return oauth2.NewClient(reqCtx, ts), nil
+}
+
+// revokeSalesforceToken revokes the refresh token, which also revokes its access tokens
+func (ctx *Context) revokeSalesforceToken(token *salesforceToken) error {
+	loginHost := token.LoginHost
+	if loginHost == "" {
+		loginHost = ctx.getSalesforceLoginHost()
+	}
+	value := token.RefreshToken
+	if value == "" {
+		value = token.AccessToken
+	}
+	// https://help.salesforce.com/articleView?id=remoteaccess_revoke_token.htm
+	res, err := http.PostForm("https://"+loginHost+"/services/oauth2/revoke", url.Values{"token": {value}})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to revoke Salesforce token: %s", res.Status)
+	}
+	return nil
+}
+
+func revokeSlackToken(token string) error {
+	return callSlackAPI("auth.revoke", url.Values{"token": {token}})
+}
+
+// logout revokes the tokens of the user and deletes them with the notify channel
+func (ctx *Context) logout() (bool, error) {
+	if ctx.UserID == "" {
+		return false, errors.New("UserID is not set")
+	}
+	loggedIn := false
+	salesforceToken, err := ctx.getSalesforceTokenForUser()
+	if err != nil {
+		return false, err
+	}
+	slackToken, err := ctx.getSlackAccessTokenForUser()
+	if err != nil {
+		return false, err
+	}
+	if salesforceToken != nil {
+		loggedIn = true
+		if err := ctx.revokeSalesforceToken(salesforceToken); err != nil {
+			fmt.Printf("Revoke Salesforce Token Error: %+v\n", err.Error())
+		}
+	}
+	if slackToken != "" {
+		loggedIn = true
+		if err := revokeSlackToken(slackToken); err != nil {
+			fmt.Printf("Revoke Slack Token Error: %+v\n", err.Error())
+		}
+	}
+	for _, hashKey := range []string{
+		ctx.SalesforceTokenStoreKey,
+		ctx.SlackTokenStoreKey,
+		ctx.NotifyChannelStoreKey,
+		ctx.TimeZoneStoreKey,
+		ctx.ReminderStoreKey,
+		ctx.LeaveNudgeStoreKey,
+		ctx.RestWatchdogStoreKey,
+		ctx.PresenceStoreKey,
+	} {
+		if err := ctx.deleteVariableInHash(hashKey); err != nil {
+			return loggedIn, err
+		}
 	}
-	return oauth2.NewClient(oauth2.NoContext, ts)
+	return loggedIn, nil
 }
@@ -1,7 +1,10 @@
 package app
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
@@ -45,7 +48,7 @@ func TestSetAndGetSalesforceAccessToken(t *testing.T) {
 		Expiry:       time.Now().Add(-10 * time.Hour),
 	}
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 	req, _ := http.NewRequest(http.MethodGet, "https://example.com/test", nil)
 	ctx := app.createContext(req)
 	err := ctx.setSalesforceAccessToken(token)
@@ -53,7 +56,7 @@ func TestSetAndGetSalesforceAccessToken(t *testing.T) {
 	ctx.UserID = "FOO"
 	err = ctx.setSalesforceAccessToken(token)
 	Test{false, err != nil}.Compare(t)
-	token = ctx.getSalesforceAccessTokenForUser()
+	token, _ = ctx.getSalesforceAccessTokenForUser()
 	for _, test := range []Test{
 		{"foo", token.AccessToken},
 		{"bar", token.RefreshToken},
@@ -63,7 +66,7 @@ func TestSetAndGetSalesforceAccessToken(t *testing.T) {
 	}
 	ctx = app.createContext(req)
 	ctx.UserID = "BAR"
-	token = ctx.getSalesforceAccessTokenForUser()
+	token, _ = ctx.getSalesforceAccessTokenForUser()
 	Test{true, token == nil}.Compare(t)
 }
 
@@ -88,13 +91,13 @@ func TestSetAndGetSalesforceOAuthClient(t *testing.T) {
 		Expiry:       oldExpiry,
 	}
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 	req, _ := http.NewRequest(http.MethodGet, "https://example.com/test", nil)
 	ctx := app.createContext(req)
 	ctx.UserID = "FOO"
 	ctx.TimeoutDuration = 2 * time.Hour
 	err := ctx.setSalesforceAccessToken(token)
-	token = ctx.getSalesforceAccessTokenForUser()
+	token, _ = ctx.getSalesforceAccessTokenForUser()
 	for _, test := range []Test{
 		{false, token == nil},
 		{oldExpiry.String(), token.Expiry.String()},
@@ -106,10 +109,11 @@ func TestSetAndGetSalesforceOAuthClient(t *testing.T) {
 	} {
 		test.Compare(t)
 	}
-	client := ctx.getSalesforceOAuth2Client()
-	token = ctx.getSalesforceAccessTokenForUser()
+	client, err := ctx.getSalesforceOAuth2Client(context.Background())
+	token, _ = ctx.getSalesforceAccessTokenForUser()
 	for _, test := range []Test{
 		{false, client == nil},
+		{true, err == nil},
 		{newExpiry.String(), token.Expiry.String()},
 		{"bar2", token.RefreshToken},
 		{"foo2", token.AccessToken},
@@ -121,7 +125,7 @@ func TestSetAndGetSalesforceOAuthClient(t *testing.T) {
 
 func TestSetAndGetSlackAccessToken(t *testing.T) {
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 	req, _ := http.NewRequest(http.MethodGet, "https://example.com/test", nil)
 	ctx := app.createContext(req)
 	err := ctx.setSlackAccessToken("foo")
@@ -129,10 +133,288 @@ func TestSetAndGetSlackAccessToken(t *testing.T) {
 	ctx.UserID = "FOO"
 	err = ctx.setSlackAccessToken("foo")
 	Test{false, err != nil}.Compare(t)
-	token := ctx.getSlackAccessTokenForUser()
+	token, _ := ctx.getSlackAccessTokenForUser()
 	Test{"foo", token}.Compare(t)
 	ctx = app.createContext(req)
 	ctx.UserID = "BAR"
-	token = ctx.getSlackAccessTokenForUser()
+	token, _ = ctx.getSlackAccessTokenForUser()
 	Test{"", token}.Compare(t)
 }
+
+func TestEncryptedAccessTokens(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	app.TokenCipher, _ = parseTokenEncryptionKey("k1:" + testEncryptionKey1)
+	ctx := app.createContext(nil)
+	ctx.UserID = "FOO"
+	ctx.setSlackAccessToken("foo")
+	ctx.setSalesforceAccessToken(&oauth2.Token{AccessToken: "bar", RefreshToken: "baz"})
+	slackToken, _ := app.Store.Get(app.SlackTokenStoreKey, "FOO")
+	salesforceToken, _ := app.Store.Get(app.SalesforceTokenStoreKey, "FOO")
+	decryptedSlackToken, _ := ctx.getSlackAccessTokenForUser()
+	decryptedSalesforceToken, _ := ctx.getSalesforceAccessTokenForUser()
+	for _, test := range []Test{
+		{"k1", getTokenKeyID(slackToken)},
+		{"k1", getTokenKeyID(salesforceToken)},
+		{"foo", decryptedSlackToken},
+		{"bar", decryptedSalesforceToken.AccessToken},
+		{"baz", decryptedSalesforceToken.RefreshToken},
+	} {
+		test.Compare(t)
+	}
+	ctx.TokenCipher = nil
+	decryptedSlackToken, _ = ctx.getSlackAccessTokenForUser()
+	decryptedSalesforceToken, _ = ctx.getSalesforceAccessTokenForUser()
+	for _, test := range []Test{
+		{"", decryptedSlackToken},
+		{true, decryptedSalesforceToken == nil},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestGetSalesforceLoginHost(t *testing.T) {
+	app := createMockApp()
+	ctx := app.createContext(nil)
+	Test{"login.salesforce.com", ctx.getSalesforceLoginHost()}.Compare(t)
+	ctx.SalesforceLoginHost = "test.salesforce.com"
+	ctx.SalesforceLoginHosts = map[string]string{"T123": "example.my.salesforce.com"}
+	Test{"test.salesforce.com", ctx.getSalesforceLoginHost()}.Compare(t)
+	ctx.TeamID = "T123"
+	for _, test := range []Test{
+		{"example.my.salesforce.com", ctx.getSalesforceLoginHost()},
+		{"https://example.my.salesforce.com/services/oauth2/authorize", ctx.getSalesforceOAuth2Config().Endpoint.AuthURL},
+		{"https://example.my.salesforce.com/services/oauth2/token", ctx.getSalesforceOAuth2Config().Endpoint.TokenURL},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestSalesforceInstanceURL(t *testing.T) {
+	defer gock.Off()
+	gock.New("https://test.salesforce.com").
+		Post("/services/oauth2/token").
+		Reply(200).
+		JSON(map[string]string{
+			"access_token":  "foo",
+			"refresh_token": "bar",
+			"token_type":    "Bearer",
+			"instance_url":  "https://ap1.salesforce.com",
+		})
+	app := createMockApp()
+	app.CleanStore()
+	app.SalesforceLoginHosts = map[string]string{"T123": "test.salesforce.com"}
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/test", nil)
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	ctx.TeamID = "T123"
+	token, err := ctx.getSalesforceAccessToken("code", "state")
+	Test{true, err == nil}.Compare(t)
+	ctx.setSalesforceAccessToken(token)
+	ctx = app.createContext(req)
+	ctx.UserID = "FOO"
+	stored, _ := ctx.getSalesforceTokenForUser()
+	client, err := ctx.createTimeTableClient(context.Background())
+	for _, test := range []Test{
+		{"https://ap1.salesforce.com", stored.InstanceURL},
+		{"test.salesforce.com", stored.LoginHost},
+		{true, err == nil},
+		{"https://ap1.salesforce.com/services/apexrest/Dakoku", client.Endpoint},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/slash", nil)
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	loggedIn, err := ctx.logout()
+	for _, test := range []Test{
+		{false, loggedIn},
+		{true, err == nil},
+	} {
+		test.Compare(t)
+	}
+
+	ctx.setSalesforceAccessToken(&oauth2.Token{
+		AccessToken:  "foo",
+		RefreshToken: "bar",
+		TokenType:    "Bearer",
+	})
+	ctx.setSlackAccessToken("baz")
+	ctx.setVariableInHash(ctx.NotifyChannelStoreKey, "C1234567")
+	ctx.setTimeZone("Europe/Berlin")
+	gock.New("https://login.salesforce.com").
+		Post("/services/oauth2/revoke").
+		BodyString("token=bar").
+		Reply(200)
+	gock.New("https://slack.com").
+		Post("/api/auth.revoke").
+		BodyString("token=baz").
+		Reply(200).
+		JSON(map[string]interface{}{"ok": true, "revoked": true})
+	loggedIn, err = ctx.logout()
+	salesforceToken, _ := ctx.getSalesforceAccessTokenForUser()
+	slackToken, _ := ctx.getSlackAccessTokenForUser()
+	channel, _ := ctx.getSlackNotifyChannelForUser()
+	timeZone, _ := ctx.getTimeZoneForUser()
+	for _, test := range []Test{
+		{true, loggedIn},
+		{true, err == nil},
+		{true, salesforceToken == nil},
+		{"", slackToken},
+		{"", channel},
+		{"", timeZone},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+
+	ctx.setSlackAccessToken("baz")
+	gock.New("https://slack.com").
+		Post("/api/auth.revoke").
+		Reply(200).
+		JSON(map[string]interface{}{"ok": false, "error": "invalid_auth"})
+	loggedIn, err = ctx.logout()
+	slackToken, _ = ctx.getSlackAccessTokenForUser()
+	for _, test := range []Test{
+		{true, loggedIn},
+		{true, err == nil},
+		{"", slackToken},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+
+	ctx.UserID = ""
+	_, err = ctx.logout()
+	Test{"UserID is not set", err.Error()}.Compare(t)
+}
+
+func TestRevokeTokens(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.SalesforceLoginHost = "test.salesforce.com"
+	ctx := app.createContext(nil)
+	gock.New("https://test.salesforce.com").
+		Post("/services/oauth2/revoke").
+		BodyString("token=foo").
+		Reply(400)
+	err := ctx.revokeSalesforceToken(&salesforceToken{Token: oauth2.Token{AccessToken: "foo"}})
+	Test{"Failed to revoke Salesforce token: 400 Bad Request", err.Error()}.Compare(t)
+	gock.New("https://example.my.salesforce.com").
+		Post("/services/oauth2/revoke").
+		BodyString("token=bar").
+		Reply(200)
+	err = ctx.revokeSalesforceToken(&salesforceToken{
+		Token:     oauth2.Token{AccessToken: "foo", RefreshToken: "bar"},
+		LoginHost: "example.my.salesforce.com",
+	})
+	Test{true, err == nil}.Compare(t)
+	gock.New("https://slack.com").
+		Post("/api/auth.revoke").
+		Reply(200).
+		JSON(map[string]interface{}{"ok": false, "error": "invalid_auth"})
+	err = revokeSlackToken("baz")
+	Test{"Failed to call auth.revoke: invalid_auth", err.Error()}.Compare(t)
+	Test{true, gock.IsDone()}.Compare(t)
+}
+
+func TestSalesforceTokenNotRewritten(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/test", nil)
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	ctx.TokenCipher, _ = parseTokenEncryptionKey("k1:" + testEncryptionKey1)
+	ctx.setSalesforceAccessToken(&oauth2.Token{
+		AccessToken:  "foo",
+		RefreshToken: "bar",
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(time.Hour),
+	})
+	stored, _ := ctx.getVariableInHash(ctx.SalesforceTokenStoreKey, ctx.UserID)
+	client, err := ctx.getSalesforceOAuth2Client(context.Background())
+	current, _ := ctx.getVariableInHash(ctx.SalesforceTokenStoreKey, ctx.UserID)
+	for _, test := range []Test{
+		{false, client == nil},
+		{nil, err},
+		{stored, current},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestSalesforceRefreshTokenRevoked(t *testing.T) {
+	defer gock.Off()
+	gock.New("https://login.salesforce.com").
+		Post("/services/oauth2/token").
+		Reply(400).
+		JSON(map[string]string{"error": "invalid_grant", "error_description": "expired access/refresh token"})
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/test", nil)
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	ctx.setSalesforceAccessToken(&oauth2.Token{
+		AccessToken:  "foo",
+		RefreshToken: "bar",
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(-10 * time.Hour),
+	})
+	client, err := ctx.getSalesforceOAuth2Client(context.Background())
+	stored, _ := ctx.getSalesforceTokenForUser()
+	for _, test := range []Test{
+		{true, client == nil},
+		{errRefreshTokenRevoked, err},
+		{true, stored == nil},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+	client, err = ctx.getSalesforceOAuth2Client(context.Background())
+	Test{errUnauthorized, err}.Compare(t)
+}
+
+func TestSalesforceTokenEndpointTimeout(t *testing.T) {
+	done := make(chan bool)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+	defaultTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = defaultTransport }()
+	http.DefaultTransport = server.Client().Transport
+	serverURL, _ := url.Parse(server.URL)
+	app := createMockApp()
+	app.CleanStore()
+	ctx := app.createContext(nil)
+	ctx.UserID = "FOO"
+	ctx.TeamSpiritTimeout = 50 * time.Millisecond
+	ctx.setSalesforceToken(&salesforceToken{
+		Token: oauth2.Token{
+			AccessToken:  "foo",
+			RefreshToken: "bar",
+			Expiry:       time.Now().Add(-10 * time.Hour),
+		},
+		LoginHost: serverURL.Host,
+	})
+	started := time.Now()
+	client, err := ctx.getSalesforceOAuth2Client(context.Background())
+	for _, test := range []Test{
+		{true, client == nil},
+		{errTimeout, err},
+		{true, time.Since(started) < time.Second},
+	} {
+		test.Compare(t)
+	}
+}
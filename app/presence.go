@@ -0,0 +1,246 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+const (
+	// defaultPresenceAwayAfter is how long a user may be away in Slack before a break is proposed
+	defaultPresenceAwayAfter = 15 * time.Minute
+	// presenceMinPollInterval is how often the presence is polled after it changed
+	presenceMinPollInterval = time.Minute
+	// presenceMaxPollInterval is how often the presence is polled at most while it does not change
+	presenceMaxPollInterval = 4 * time.Minute
+)
+
+// presenceWatch is the state of the presence-driven punch proposals of a user who opted in
+type presenceWatch struct {
+	TeamID string `json:"teamId"`
+	// AwaySince is the unix time the user went away, or zero while active
+	AwaySince int64 `json:"awaySince,omitempty"`
+	// RestProposed is true once a break is proposed for the current away
+	RestProposed bool `json:"restProposed,omitempty"`
+	// CheckedOn is the last date the user became active, to propose clock-in once a day
+	CheckedOn string `json:"checkedOn,omitempty"`
+	// NextCheckAt is the unix time when the presence is polled next
+	NextCheckAt int64 `json:"nextCheckAt,omitempty"`
+	// PollInterval is the polling interval in seconds, doubled while the presence does not change
+	PollInterval int64 `json:"pollInterval,omitempty"`
+}
+
+func (ctx *Context) getPresenceWatchForUser() *presenceWatch {
+	value, _ := ctx.getVariableInHash(ctx.PresenceStoreKey, ctx.UserID)
+	if value == "" {
+		return nil
+	}
+	var watch presenceWatch
+	if json.Unmarshal([]byte(value), &watch) != nil {
+		return nil
+	}
+	return &watch
+}
+
+func (ctx *Context) setPresenceWatch(watch *presenceWatch) error {
+	b, err := json.Marshal(watch)
+	if err != nil {
+		return err
+	}
+	return ctx.setVariableInHash(ctx.PresenceStoreKey, string(b))
+}
+
+// watchPresences proposes punches to the opted-in users whose Slack presence changed.
+// Presence changes are not delivered by the Events API, so they are polled by the scheduler.
+// users.getPresence is rate limited for the whole workspace, so the polling backs off while the presence does not change
+func (app *App) watchPresences(now time.Time) {
+	watches, err := app.Store.All(app.PresenceStoreKey)
+	if err != nil {
+		fmt.Printf("Load Presences Error: %+v\n", err.Error())
+		return
+	}
+	forEachUser(watches, func(userID string) {
+		ctx := app.createUserContext("", userID, now)
+		if err := ctx.watchPresence(); err != nil {
+			fmt.Printf("Watch Presence Error: %+v\n", err.Error())
+		}
+	})
+}
+
+// handleUserChange polls the presence of an opted-in user right away, because the profile is usually changed while active
+func (ctx *Context) handleUserChange(event *slackEvent) error {
+	watch := ctx.getPresenceWatchForUser()
+	if watch == nil {
+		return nil
+	}
+	watch.NextCheckAt = 0
+	watch.PollInterval = 0
+	if err := ctx.setPresenceWatch(watch); err != nil {
+		return err
+	}
+	return ctx.watchPresence()
+}
+
+// schedulePresenceCheck sets the next poll of the presence, spread out so that the users are not polled at once
+func (ctx *Context) schedulePresenceCheck(watch *presenceWatch, changed bool) {
+	interval := 2 * time.Duration(watch.PollInterval) * time.Second
+	if changed || interval < presenceMinPollInterval {
+		interval = presenceMinPollInterval
+	}
+	if interval > presenceMaxPollInterval {
+		interval = presenceMaxPollInterval
+	}
+	watch.PollInterval = int64(interval / time.Second)
+	jitter := time.Duration(rand.Int63n(int64(interval/4) + 1))
+	watch.NextCheckAt = ctx.now().Add(interval - jitter).Unix()
+}
+
+// watchPresence checks the Slack presence of the user and sends a DM to confirm the proposed punch
+func (ctx *Context) watchPresence() error {
+	watch := ctx.getPresenceWatchForUser()
+	if watch == nil || ctx.now().Unix() < watch.NextCheckAt {
+		return nil
+	}
+	ctx.TeamID = watch.TeamID
+	token, err := ctx.getSlackAPIToken()
+	if err != nil || token == "" {
+		return err
+	}
+	presence, err := slack.New(token).GetUserPresence(ctx.UserID)
+	if rateLimited, ok := err.(*slack.RateLimitedError); ok {
+		watch.NextCheckAt = ctx.now().Add(rateLimited.RetryAfter).Unix()
+		ctx.setPresenceWatch(watch)
+	}
+	if err != nil {
+		return err
+	}
+	active := presence.Presence == "active"
+	ctx.schedulePresenceCheck(watch, active == (watch.AwaySince != 0))
+	if active {
+		return ctx.proposeAttend(watch)
+	}
+	return ctx.proposeRest(watch)
+}
+
+// proposeAttend proposes to clock in when the user becomes active for the first time of the day
+func (ctx *Context) proposeAttend(watch *presenceWatch) error {
+	now := ctx.localNow()
+	today := now.Format(timeTableDateLayout)
+	watch.AwaySince = 0
+	watch.RestProposed = false
+	if err := ctx.setPresenceWatch(watch); err != nil || watch.CheckedOn == today {
+		return err
+	}
+	reqCtx, cancel := ctx.newRequestContext()
+	defer cancel()
+	client, err := ctx.createTimeTableClient(reqCtx)
+	if err != nil {
+		return err
+	}
+	tt, err := client.GetCurrentTimeTable(reqCtx, now)
+	if err != nil {
+		return err
+	}
+	if !tt.IsAttending() && (tt.IsHoliday == nil || !*tt.IsHoliday) {
+		if err := ctx.sendDirectMessage(getPresenceAttendDirectMessage()); err != nil {
+			return err
+		}
+	}
+	// saved after the proposal, so that it is retried on the next poll when TeamSpirit or Slack failed
+	watch.CheckedOn = today
+	return ctx.setPresenceWatch(watch)
+}
+
+// proposeRest proposes a break from the time the user went away once the user is away for longer than PresenceAwayAfter
+func (ctx *Context) proposeRest(watch *presenceWatch) error {
+	if watch.AwaySince == 0 {
+		watch.AwaySince = ctx.now().Unix()
+	}
+	if err := ctx.setPresenceWatch(watch); err != nil {
+		return err
+	}
+	awaySince := time.Unix(watch.AwaySince, 0)
+	if watch.RestProposed || ctx.now().Sub(awaySince) < ctx.PresenceAwayAfter {
+		return nil
+	}
+	reqCtx, cancel := ctx.newRequestContext()
+	defer cancel()
+	client, err := ctx.createTimeTableClient(reqCtx)
+	if err != nil {
+		return err
+	}
+	now := ctx.localNow()
+	tt, err := client.GetCurrentTimeTable(reqCtx, now)
+	if err != nil {
+		return err
+	}
+	if tt.IsAttending() && !tt.IsLeaving() && !tt.IsResting() {
+		from := tt.convertTime(awaySince.In(now.Location())).Int64
+		if err := ctx.sendDirectMessage(getPresenceRestDirectMessage(from, tt.convertTime(now).Int64)); err != nil {
+			return err
+		}
+	}
+	watch.RestProposed = true
+	return ctx.setPresenceWatch(watch)
+}
+
+func getPresenceAttendDirectMessage() *message {
+	text := "Slack がアクティブになりました。出勤しますか？ :sunrise:"
+	return &message{
+		Msg: slack.Msg{Text: text},
+		Blocks: []block{
+			sectionBlock(text),
+			actionsBlock(callbackIDPresence,
+				buttonElement(actionTypeAttend, "出勤する", "primary"),
+			),
+		},
+	}
+}
+
+func getPresenceRestDirectMessage(from, now int64) *message {
+	text := fmt.Sprintf("%s から%s離席しています。%s からの休憩を開始しますか？ :coffee:", formatMinutes(from), formatDuration(now-from), formatMinutes(from))
+	rest := buttonElement(actionTypeRestAt, "休憩を開始する", "primary")
+	rest.Value = strconv.FormatInt(from, 10)
+	return &message{
+		Msg: slack.Msg{Text: text},
+		Blocks: []block{
+			sectionBlock(text),
+			actionsBlock(callbackIDPresence, rest),
+		},
+	}
+}
+
+func (ctx *Context) getPresenceSlackMessage(input *commandInput) (*message, error) {
+	enabledText := fmt.Sprintf("Slack がその日初めてアクティブになったら出勤を、%d 分以上離席したら休憩を DM で提案します", ctx.PresenceAwayAfter/time.Minute)
+	if len(input.Args) == 0 {
+		text := "Slack のプレゼンスによる打刻の提案は無効です"
+		if ctx.getPresenceWatchForUser() != nil {
+			text = enabledText
+		}
+		return getEphemeralSlackMessage(text), nil
+	}
+	switch input.Args[0] {
+	case "on":
+		token, err := ctx.getSlackAPIToken()
+		if err != nil {
+			return nil, err
+		}
+		if token == "" {
+			return ctx.getAuthenticateSlackMessage(input.State, input.Name)
+		}
+		if err := ctx.setPresenceWatch(&presenceWatch{TeamID: input.State.TeamID}); err != nil {
+			return nil, err
+		}
+		return getEphemeralSlackMessage(enabledText + " :eyes:"), nil
+	case "off":
+		if err := ctx.deleteVariableInHash(ctx.PresenceStoreKey); err != nil {
+			return nil, err
+		}
+		return getEphemeralSlackMessage("Slack のプレゼンスによる打刻の提案を停止しました"), nil
+	}
+	return getErrorSlackMessage("`on` または `off` を指定してください"), nil
+}
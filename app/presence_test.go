@@ -0,0 +1,309 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"golang.org/x/oauth2"
+	null "gopkg.in/guregu/null.v3"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func setupPresenceGock(presence string) {
+	gock.New("https://slack.com").
+		Post("/api/users.getPresence").
+		BodyString("user=FOO").
+		Reply(200).
+		JSON(map[string]interface{}{"ok": true, "presence": presence})
+}
+
+func TestWatchPresences(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := createLoggedInTestContext(app, getMockTime())
+	ctx.setPresenceWatch(&presenceWatch{TeamID: "T12345678"})
+
+	// first active presence of the day
+	setupPresenceGock("active")
+	setupTimeTableGocks([]timeTableItem{}, &[]bool{false}[0])
+	setupDirectMessageGock("value%22%3A%22attend%22", "baz")
+	app.watchPresences(getMockTime())
+	for _, test := range []Test{
+		{true, gock.IsDone()},
+		{"2018-09-01", ctx.getPresenceWatchForUser().CheckedOn},
+	} {
+		test.Compare(t)
+	}
+
+	// proposed once a day
+	setupPresenceGock("active")
+	app.watchPresences(getMockTime().Add(presenceMaxPollInterval))
+	Test{true, gock.IsDone()}.Compare(t)
+
+	// away
+	away := getMockTime().Add(2 * presenceMaxPollInterval)
+	setupPresenceGock("away")
+	app.watchPresences(away)
+	Test{away.Unix(), ctx.getPresenceWatchForUser().AwaySince}.Compare(t)
+
+	// not proposed until away for PresenceAwayAfter
+	setupPresenceGock("away")
+	app.watchPresences(away.Add(10 * time.Minute))
+	Test{false, ctx.getPresenceWatchForUser().RestProposed}.Compare(t)
+
+	setupPresenceGock("away")
+	setupTimeTableGocks([]timeTableItem{{From: null.IntFrom(540), Type: 1}}, &[]bool{false}[0])
+	setupDirectMessageGock("value%22%3A%22680%22", "baz")
+	app.watchPresences(away.Add(16 * time.Minute))
+	for _, test := range []Test{
+		{true, gock.IsDone()},
+		{true, ctx.getPresenceWatchForUser().RestProposed},
+	} {
+		test.Compare(t)
+	}
+
+	// back to active
+	setupPresenceGock("active")
+	app.watchPresences(away.Add(20 * time.Minute))
+	watch := ctx.getPresenceWatchForUser()
+	for _, test := range []Test{
+		{true, gock.IsDone()},
+		{int64(0), watch.AwaySince},
+		{false, watch.RestProposed},
+		{"2018-09-01", watch.CheckedOn},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestWatchPresenceNotProposed(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	for _, test := range []struct {
+		presence string
+		items    []timeTableItem
+	}{
+		{"active", []timeTableItem{{From: null.IntFrom(540), Type: 1}}},
+		{"away", []timeTableItem{}},
+		{"away", []timeTableItem{{From: null.IntFrom(540), Type: 1}, {From: null.IntFrom(600), Type: 21}}},
+	} {
+		app.CleanStore()
+		ctx := createLoggedInTestContext(app, getMockTime())
+		ctx.setPresenceWatch(&presenceWatch{AwaySince: getMockTime().Add(-time.Hour).Unix()})
+		setupPresenceGock(test.presence)
+		setupTimeTableGocks(test.items, &[]bool{false}[0])
+		for _, test := range []Test{
+			{nil, ctx.watchPresence()},
+			{true, gock.IsDone()},
+		} {
+			test.Compare(t)
+		}
+	}
+}
+
+func TestWatchPresenceBackoff(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := createLoggedInTestContext(app, getMockTime())
+	ctx.setPresenceWatch(&presenceWatch{TeamID: "T12345678", CheckedOn: "2018-09-01"})
+	now := getMockTime()
+	for _, expected := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 4 * time.Minute} {
+		setupPresenceGock("active")
+		ctx.now = func() time.Time { return now }
+		for _, test := range []Test{
+			{nil, ctx.watchPresence()},
+			{true, gock.IsDone()},
+			{int64(expected / time.Second), ctx.getPresenceWatchForUser().PollInterval},
+			{true, ctx.getPresenceWatchForUser().NextCheckAt <= now.Add(expected).Unix()},
+			{true, ctx.getPresenceWatchForUser().NextCheckAt >= now.Add(expected*3/4).Unix()},
+		} {
+			test.Compare(t)
+		}
+		now = time.Unix(ctx.getPresenceWatchForUser().NextCheckAt, 0)
+	}
+
+	// not polled until NextCheckAt
+	setupPresenceGock("away")
+	ctx.now = func() time.Time { return now.Add(-time.Second) }
+	Test{nil, ctx.watchPresence()}.Compare(t)
+	Test{true, gock.IsPending()}.Compare(t)
+
+	// polled every minute again once the presence changed
+	ctx.now = func() time.Time { return now }
+	Test{nil, ctx.watchPresence()}.Compare(t)
+	Test{int64(60), ctx.getPresenceWatchForUser().PollInterval}.Compare(t)
+}
+
+func TestWatchPresenceRateLimited(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := createLoggedInTestContext(app, getMockTime())
+	ctx.setPresenceWatch(&presenceWatch{TeamID: "T12345678"})
+	gock.New("https://slack.com").
+		Post("/api/users.getPresence").
+		Reply(429).
+		SetHeader("Retry-After", "30")
+	for _, test := range []Test{
+		{true, ctx.watchPresence() != nil},
+		{getMockTime().Add(30 * time.Second).Unix(), ctx.getPresenceWatchForUser().NextCheckAt},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestProposeAttendRetried(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := createLoggedInTestContext(app, getMockTime())
+	ctx.setPresenceWatch(&presenceWatch{TeamID: "T12345678"})
+	setupPresenceGock("active")
+	gock.New("https://teamspirit-1234.cloudforce.test").
+		Get("/services/apexrest/Dakoku").
+		Reply(400).
+		JSON([]map[string]string{{"errorCode": "INVALID", "message": "Invalid"}})
+	for _, test := range []Test{
+		{true, ctx.watchPresence() != nil},
+		{"", ctx.getPresenceWatchForUser().CheckedOn},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestHandleUserChange(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := createLoggedInTestContext(app, getMockTime())
+	ctx.setPresenceWatch(&presenceWatch{
+		TeamID:       "T12345678",
+		CheckedOn:    "2018-09-01",
+		NextCheckAt:  getMockTime().Add(time.Hour).Unix(),
+		PollInterval: int64(presenceMaxPollInterval / time.Second),
+	})
+	setupPresenceGock("away")
+	var event slackEvent
+	json.Unmarshal([]byte(`{"type":"user_change","user":{"id":"FOO","name":"foo","tz":"Asia/Tokyo"}}`), &event)
+	for _, test := range []Test{
+		{"FOO", string(event.User)},
+		{"user_change", event.name()},
+		{nil, ctx.handleUserChange(&event)},
+		{true, gock.IsDone()},
+		{getMockTime().Unix(), ctx.getPresenceWatchForUser().AwaySince},
+		{int64(60), ctx.getPresenceWatchForUser().PollInterval},
+	} {
+		test.Compare(t)
+	}
+
+	// users who did not opt in are ignored
+	bar := app.createUserContext("T12345678", "BAR", getMockTime())
+	Test{nil, bar.handleUserChange(&slackEvent{Type: "user_change", User: "BAR"})}.Compare(t)
+}
+
+func TestGetPresenceRestDirectMessage(t *testing.T) {
+	msg := getPresenceRestDirectMessage(673, 689)
+	actions := msg.getActions()
+	for _, test := range []Test{
+		{"11:13 から16分離席しています。11:13 からの休憩を開始しますか？ :coffee:", msg.Text},
+		{callbackIDPresence, msg.Blocks[1].BlockID},
+		{actionTypeRestAt, actions[0].ActionID},
+		{"673", actions[0].Value},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestPresenceSubcommand(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/slash", nil)
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	command := slack.SlashCommand{TeamID: "T12345678", UserID: "FOO", Text: "presence on"}
+
+	msg, err := ctx.getSlackMessage(command)
+	for _, test := range []Test{
+		{true, err == nil},
+		{"Slack で認証を行って、再度 `/ts presence` コマンドを実行してください :bow:", msg.Blocks[0].Text.Text},
+		{true, ctx.getPresenceWatchForUser() == nil},
+	} {
+		test.Compare(t)
+	}
+
+	ctx.setSlackAccessToken("baz")
+	for _, test := range []struct {
+		text     string
+		expected string
+		enabled  bool
+	}{
+		{"presence on", "Slack がその日初めてアクティブになったら出勤を、15 分以上離席したら休憩を DM で提案します :eyes:", true},
+		{"presence", "Slack がその日初めてアクティブになったら出勤を、15 分以上離席したら休憩を DM で提案します", true},
+		{"presence foo", "`on` または `off` を指定してください", true},
+		{"presence off", "Slack のプレゼンスによる打刻の提案を停止しました", false},
+		{"presence", "Slack のプレゼンスによる打刻の提案は無効です", false},
+	} {
+		command.Text = test.text
+		msg, err := ctx.getSlackMessage(command)
+		for _, test := range []Test{
+			{nil, err},
+			{test.expected, msg.Text},
+			{test.enabled, ctx.getPresenceWatchForUser() != nil},
+		} {
+			test.Compare(t)
+		}
+	}
+}
+
+func TestRestAtAction(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/interactive", strings.NewReader(""))
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	ctx.now = getMockTime
+	ctx.setSalesforceAccessToken(&oauth2.Token{
+		AccessToken:  "foo",
+		RefreshToken: "bar",
+		TokenType:    "Bearer",
+	})
+	attending := []timeTableItem{{From: null.IntFrom(540), Type: 1}}
+	for _, test := range []struct {
+		value    string
+		expected string
+	}{
+		{"630", "10:30 に休憩を開始しました :coffee:"},
+		{"700", "未来の時刻は指定できません"},
+	} {
+		setupTimeTableGocks(attending, &[]bool{false}[0])
+		if test.value == "630" {
+			gock.New("https://teamspirit-1234.cloudforce.test").
+				Post("/services/apexrest/Dakoku").
+				BodyString(`{"from":630,"to":null,"type":21}`).
+				Reply(200).
+				BodyString(`"OK"`)
+		}
+		msg, responseURL, err := ctx.getActionCallback(&actionCallback{
+			CallbackID:    callbackIDPresence,
+			ActionName:    actionTypeRestAt,
+			SelectedValue: test.value,
+			ResponseURL:   "https://hooks.slack.test/coolhook",
+			UserID:        "FOO",
+		})
+		for _, test := range []Test{
+			{nil, err},
+			{"https://hooks.slack.test/coolhook", responseURL},
+			{test.expected, msg.Text},
+			{true, gock.IsDone()},
+		} {
+			test.Compare(t)
+		}
+	}
+}
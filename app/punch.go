@@ -0,0 +1,371 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+	null "gopkg.in/guregu/null.v3"
+)
+
+// punchTime is the time given to a punch subcommand in minutes
+type punchTime struct {
+	From null.Int
+	To   null.Int
+}
+
+var punchMessages = map[string]string{
+	actionTypeAttend: "出勤しました :office:",
+	actionTypeRest:   "休憩を開始しました :coffee:",
+	actionTypeUnrest: "休憩を終了しました :computer:",
+	actionTypeAway:   "私用外出を開始しました :walking:",
+	actionTypeLeave:  "退勤しました :house:",
+}
+
+var punchAtMessages = map[string]string{
+	actionTypeAttend: "%s に出勤しました :office:",
+	actionTypeRest:   "%s に休憩を開始しました :coffee:",
+	actionTypeUnrest: "%s に休憩を終了しました :computer:",
+	actionTypeAway:   "%s に私用外出を開始しました :walking:",
+	actionTypeLeave:  "%s に退勤しました :house:",
+}
+
+const (
+	backFromAwayMessage   = "私用外出から戻りました :computer:"
+	backFromAwayAtMessage = "%s に私用外出から戻りました :computer:"
+)
+
+const punchFailedMessage = "勤務表の更新に失敗しました :warning:"
+
+var errInvalidPunchTime = errors.New("時刻は `9:05` や `12:00-13:00` の形式で指定してください")
+
+// parseClock parses H:MM into minutes. Hours up to 47 are accepted for shifts past midnight.
+func parseClock(value string) (int64, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 || len(parts[1]) != 2 {
+		return 0, errInvalidPunchTime
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 47 {
+		return 0, errInvalidPunchTime
+	}
+	min, err := strconv.Atoi(parts[1])
+	if err != nil || min < 0 || min > 59 {
+		return 0, errInvalidPunchTime
+	}
+	return int64(hour*60 + min), nil
+}
+
+// parsePunchTime parses H:MM or H:MM-H:MM
+func parsePunchTime(value string) (*punchTime, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) > 2 {
+		return nil, errInvalidPunchTime
+	}
+	from, err := parseClock(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	pt := &punchTime{From: null.IntFrom(from)}
+	if len(parts) == 2 {
+		to, err := parseClock(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		pt.To = null.IntFrom(to)
+	}
+	return pt, nil
+}
+
+// resolve returns the punch time at the latest clock which is not after now in minutes of the work day
+func (pt *punchTime) resolve(now int64) *punchTime {
+	resolved := *pt
+	if pt.From.Valid {
+		resolved.From = null.IntFrom(resolveClock(pt.From.Int64, now))
+	}
+	if pt.To.Valid {
+		resolved.To = null.IntFrom(resolveClock(pt.To.Int64, now))
+	}
+	return &resolved
+}
+
+// getPunchText returns the message for the action, which must be called before the action is applied to the time table
+func getPunchText(tt *timeTable, action string, pt *punchTime) string {
+	if action == actionTypeUnrest {
+		if item := tt.getOpenRest(); item != nil && item.IsAway() {
+			if pt != nil {
+				return fmt.Sprintf(backFromAwayAtMessage, pt.String())
+			}
+			return backFromAwayMessage
+		}
+	}
+	if pt == nil {
+		return punchMessages[action]
+	}
+	if pt.To.Valid && action == actionTypeAway {
+		return pt.String() + " の私用外出を登録しました :walking:"
+	}
+	if pt.To.Valid {
+		return pt.String() + " の休憩を登録しました :coffee:"
+	}
+	return fmt.Sprintf(punchAtMessages[action], pt.String())
+}
+
+func (pt *punchTime) String() string {
+	if pt.To.Valid {
+		return formatMinutes(pt.From.Int64) + " - " + formatMinutes(pt.To.Int64)
+	}
+	return formatMinutes(pt.From.Int64)
+}
+
+func (tt *timeTable) getAttendanceFrom() null.Int {
+	if item := tt.getAttendance(); item != nil {
+		return item.From
+	}
+	return null.Int{}
+}
+
+// validatePunchTime returns the reason why the action cannot be performed at the time, or empty string if it can
+func (tt *timeTable) validatePunchTime(action string, pt *punchTime, now int64) string {
+	if pt.To.Valid && action != actionTypeRest && action != actionTypeAway {
+		return "時間帯を指定できるのは `/ts break` と `/ts away` のみです"
+	}
+	if pt.To.Valid && pt.From.Int64 >= pt.To.Int64 {
+		return "終了時刻は開始時刻より後にしてください"
+	}
+	if pt.From.Int64 > now || pt.To.Valid && pt.To.Int64 > now {
+		return "未来の時刻は指定できません"
+	}
+	attendanceFrom := tt.getAttendanceFrom()
+	switch action {
+	case actionTypeAttend:
+		for _, item := range tt.Items {
+			if item.IsRest() && item.From.Valid && item.From.Int64 < pt.From.Int64 {
+				return "出勤時刻は休憩より前にしてください"
+			}
+		}
+	case actionTypeLeave:
+		if attendanceFrom.Valid && pt.From.Int64 <= attendanceFrom.Int64 {
+			return "退勤時刻は出勤時刻より後にしてください"
+		}
+		for _, item := range tt.Items {
+			if item.IsRest() && (item.From.Valid && item.From.Int64 >= pt.From.Int64 || item.To.Valid && item.To.Int64 > pt.From.Int64) {
+				return "退勤時刻は休憩より後にしてください"
+			}
+		}
+	case actionTypeRest, actionTypeAway:
+		if attendanceFrom.Valid && pt.From.Int64 < attendanceFrom.Int64 {
+			return "休憩は出勤時刻より後にしてください"
+		}
+		to := pt.To.Int64
+		if !pt.To.Valid {
+			to = now + 1
+		}
+		for _, item := range tt.Items {
+			if !item.IsRest() || !item.From.Valid {
+				continue
+			}
+			itemTo := item.To.Int64
+			if !item.To.Valid {
+				itemTo = now + 1
+			}
+			if pt.From.Int64 < itemTo && item.From.Int64 < to {
+				return "既存の休憩と重複しています"
+			}
+		}
+	case actionTypeUnrest:
+		for _, item := range tt.Items {
+			if item.IsRest() && !item.To.Valid && item.From.Valid && pt.From.Int64 <= item.From.Int64 {
+				return "終了時刻は開始時刻より後にしてください"
+			}
+		}
+	}
+	return ""
+}
+
+// getPunchError returns the reason why the action cannot be performed on the time table, or empty string if it can
+func (ctx *Context) getPunchError(tt *timeTable, action string, pt *punchTime) string {
+	if tt.IsLeaving() {
+		return "既に退勤済です。打刻修正は <https://" + ctx.TeamSpiritHost + "|TeamSpirit> で行なってください。"
+	}
+	if tt.IsHoliday != nil && *tt.IsHoliday == true {
+		return "本日は休日です :sunny:"
+	}
+	switch action {
+	case actionTypeAttend:
+		// the previous day's time table is only returned while attending
+		if tt.IsAttending() {
+			return "既に出勤済です"
+		}
+	case actionTypeRest, actionTypeAway:
+		if !tt.IsAttending() {
+			return "まだ出勤していません"
+		}
+		if item := tt.getOpenRest(); item != nil && (pt == nil || !pt.To.Valid) {
+			return "既に" + item.getLabel() + "中です"
+		}
+	case actionTypeUnrest:
+		if !tt.IsResting() {
+			return "休憩中ではありません"
+		}
+	case actionTypeLeave:
+		if !tt.IsAttending() {
+			return "まだ出勤していません"
+		}
+		if tt.IsResting() {
+			return "休憩中です。休憩を終了してから退勤してください"
+		}
+	}
+	return ""
+}
+
+// getPunchLanded returns a function which reports whether the action is already recorded in the time table
+func getPunchLanded(action string, pt *punchTime) func(tt *timeTable) bool {
+	return func(tt *timeTable) bool {
+		switch action {
+		case actionTypeAttend:
+			from := tt.getAttendanceFrom()
+			return from.Valid && (pt == nil || from.Int64 == pt.From.Int64)
+		case actionTypeLeave:
+			attendance := tt.getAttendance()
+			return attendance != nil && attendance.To.Valid && (pt == nil || attendance.To.Int64 == pt.From.Int64)
+		case actionTypeRest, actionTypeAway:
+			if pt == nil {
+				return tt.IsResting()
+			}
+			for _, item := range tt.Items {
+				if item.IsRest() && item.IsAway() == (action == actionTypeAway) && item.From == pt.From && item.To == pt.To {
+					return true
+				}
+			}
+			return false
+		case actionTypeUnrest:
+			return !tt.IsResting()
+		}
+		return false
+	}
+}
+
+// updateTimeTable applies the operation to the time table and submits it to TeamSpirit.
+// If the time table is modified in TeamSpirit after it was fetched, the operation is applied to the latest one and submitted once again.
+func (ctx *Context) updateTimeTable(reqCtx context.Context, client *timeTableClient, tt *timeTable, apply func(tt *timeTable), landed func(tt *timeTable) bool) (bool, error) {
+	apply(tt)
+	update := func() (bool, error) {
+		ok, err := client.UpdateTimeTable(reqCtx, tt)
+		if err != errConflict {
+			return ok, err
+		}
+		latest, err := client.GetTimeTableOn(reqCtx, tt.Date)
+		if err != nil {
+			return false, err
+		}
+		*tt = *latest
+		if landed(tt) {
+			return true, nil
+		}
+		apply(tt)
+		return client.UpdateTimeTable(reqCtx, tt)
+	}
+	return client.retry(reqCtx, tt.Date, update, landed)
+}
+
+// punch applies the action to the time table and submits it to TeamSpirit
+func (ctx *Context) punch(reqCtx context.Context, client *timeTableClient, tt *timeTable, action string, now time.Time) (bool, error) {
+	landed := getPunchLanded(action, nil)
+	switch action {
+	case actionTypeAttend, actionTypeLeave:
+		return client.retry(reqCtx, tt.Date, func() (bool, error) {
+			// the minutes are sent because the clock of TeamSpirit may be in another time zone than the user
+			return client.SetAttendanceAt(reqCtx, tt.Date, action == actionTypeAttend, tt.convertTime(now))
+		}, landed)
+	}
+	return ctx.updateTimeTable(reqCtx, client, tt, func(tt *timeTable) {
+		switch action {
+		case actionTypeRest:
+			tt.Rest(now)
+		case actionTypeAway:
+			tt.Away(now)
+		case actionTypeUnrest:
+			tt.Unrest(now)
+		}
+	}, landed)
+}
+
+// punchAt applies the action at the given time and submits it to TeamSpirit
+func (ctx *Context) punchAt(reqCtx context.Context, client *timeTableClient, tt *timeTable, action string, pt *punchTime) (bool, error) {
+	landed := getPunchLanded(action, pt)
+	switch action {
+	case actionTypeAttend, actionTypeLeave:
+		return client.retry(reqCtx, tt.Date, func() (bool, error) {
+			return client.SetAttendanceAt(reqCtx, tt.Date, action == actionTypeAttend, pt.From)
+		}, landed)
+	}
+	return ctx.updateTimeTable(reqCtx, client, tt, func(tt *timeTable) {
+		switch action {
+		case actionTypeRest:
+			tt.AddRest(pt.From, pt.To)
+		case actionTypeAway:
+			tt.AddAway(pt.From, pt.To)
+		case actionTypeUnrest:
+			tt.UnrestAt(pt.From)
+		}
+	}, landed)
+}
+
+func (ctx *Context) notifyChannel(text string) {
+	slackToken, err := ctx.getSlackAccessTokenForUser()
+	if err != nil {
+		fmt.Printf("Notify Channel Error: %+v\n", err.Error())
+		return
+	}
+	slackChannel, err := ctx.getSlackNotifyChannelForUser()
+	if err != nil {
+		fmt.Printf("Notify Channel Error: %+v\n", err.Error())
+		return
+	}
+	if slackToken != "" && slackChannel != "" {
+		slack.New(slackToken).PostMessage(slackChannel, text, slack.PostMessageParameters{AsUser: true})
+	}
+}
+
+func getPunchSubcommandHandler(action string) func(ctx *Context, input *commandInput) (*message, error) {
+	return func(ctx *Context, input *commandInput) (*message, error) {
+		var pt *punchTime
+		now := input.TimeTable.convertTime(ctx.localNow()).Int64
+		if len(input.Args) > 0 {
+			var err error
+			if pt, err = parsePunchTime(input.Args[0]); err != nil {
+				return getErrorSlackMessage(err.Error()), nil
+			}
+			pt = pt.resolve(now)
+		}
+		if text := ctx.getPunchError(input.TimeTable, action, pt); text != "" {
+			return getErrorSlackMessage(text), nil
+		}
+		text := getPunchText(input.TimeTable, action, pt)
+		var ok bool
+		var err error
+		if pt != nil {
+			if text := input.TimeTable.validatePunchTime(action, pt, now); text != "" {
+				return getErrorSlackMessage(text), nil
+			}
+			ok, err = ctx.punchAt(input.RequestContext, input.Client, input.TimeTable, action, pt)
+		} else {
+			ok, err = ctx.punch(input.RequestContext, input.Client, input.TimeTable, action, ctx.localNow())
+		}
+		if !ok || err != nil {
+			return getErrorSlackMessage(getPunchFailedText(err)), nil
+		}
+		ctx.notifyChannel(text)
+		return &message{
+			Msg: slack.Msg{
+				ResponseType: "in_channel",
+				Text:         text,
+			},
+		}, nil
+	}
+}
@@ -0,0 +1,427 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"golang.org/x/oauth2"
+	null "gopkg.in/guregu/null.v3"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func TestGetPunchError(t *testing.T) {
+	app := createMockApp()
+	ctx := app.createContext(nil)
+	notAttending := &timeTable{Items: []timeTableItem{}}
+	attending := &timeTable{Items: []timeTableItem{
+		{From: null.IntFrom(10 * 60), Type: 1},
+	}}
+	resting := &timeTable{Items: []timeTableItem{
+		{From: null.IntFrom(10 * 60), Type: 1},
+		{From: null.IntFrom(12 * 60), Type: 21},
+	}}
+	leaving := &timeTable{Items: []timeTableItem{
+		{From: null.IntFrom(10 * 60), To: null.IntFrom(19 * 60), Type: 1},
+	}}
+	away := &timeTable{Items: []timeTableItem{
+		{From: null.IntFrom(10 * 60), Type: 1},
+		{From: null.IntFrom(12 * 60), Type: 22},
+	}}
+	holiday := &timeTable{Items: []timeTableItem{}, IsHoliday: &[]bool{true}[0]}
+	ctx.now = getMockTime
+	for _, test := range []Test{
+		{"", ctx.getPunchError(notAttending, actionTypeAttend, nil)},
+		{"まだ出勤していません", ctx.getPunchError(notAttending, actionTypeRest, nil)},
+		{"休憩中ではありません", ctx.getPunchError(notAttending, actionTypeUnrest, nil)},
+		{"まだ出勤していません", ctx.getPunchError(notAttending, actionTypeLeave, nil)},
+		{"既に出勤済です", ctx.getPunchError(attending, actionTypeAttend, nil)},
+		{"", ctx.getPunchError(attending, actionTypeRest, nil)},
+		{"休憩中ではありません", ctx.getPunchError(attending, actionTypeUnrest, nil)},
+		{"", ctx.getPunchError(attending, actionTypeLeave, nil)},
+		{"既に休憩中です", ctx.getPunchError(resting, actionTypeRest, nil)},
+		{"既に休憩中です", ctx.getPunchError(resting, actionTypeAway, nil)},
+		{"既に私用外出中です", ctx.getPunchError(away, actionTypeAway, nil)},
+		{"既に私用外出中です", ctx.getPunchError(away, actionTypeRest, nil)},
+		{"", ctx.getPunchError(away, actionTypeRest, &punchTime{From: null.IntFrom(11 * 60), To: null.IntFrom(11*60 + 30)})},
+		{"", ctx.getPunchError(attending, actionTypeAway, nil)},
+		{"まだ出勤していません", ctx.getPunchError(notAttending, actionTypeAway, nil)},
+		{"", ctx.getPunchError(away, actionTypeUnrest, nil)},
+		{"", ctx.getPunchError(resting, actionTypeUnrest, nil)},
+		{"休憩中です。休憩を終了してから退勤してください", ctx.getPunchError(resting, actionTypeLeave, nil)},
+		{"既に退勤済です。打刻修正は <https://teamspirit-1234.cloudforce.test|TeamSpirit> で行なってください。", ctx.getPunchError(leaving, actionTypeAttend, nil)},
+		{"本日は休日です :sunny:", ctx.getPunchError(holiday, actionTypeAttend, nil)},
+	} {
+		test.Compare(t)
+	}
+}
+
+func testPunchSubcommand(t *testing.T, text string, items []timeTableItem, method string, response string, expected string) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/slash", bytes.NewBufferString(""))
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	ctx.now = getMockTime
+	ctx.setSalesforceAccessToken(&oauth2.Token{
+		AccessToken:  "foo",
+		RefreshToken: "bar",
+		TokenType:    "Bearer",
+	})
+	setupTimeTableGocks(items, &[]bool{false}[0])
+	if method == http.MethodPut {
+		gock.New("https://teamspirit-1234.cloudforce.test").
+			Put("/services/apexrest/Dakoku").
+			Reply(200).
+			BodyString(response)
+	} else if method == http.MethodPost {
+		gock.New("https://teamspirit-1234.cloudforce.test").
+			Post("/services/apexrest/Dakoku").
+			Reply(200).
+			BodyString(response)
+	}
+	msg, err := ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", UserID: "FOO", Text: text})
+	for _, test := range []Test{
+		{true, err == nil},
+		{expected, msg.Text},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestPunchSubcommands(t *testing.T) {
+	attending := []timeTableItem{
+		{From: null.IntFrom(10 * 60), Type: 1},
+	}
+	resting := []timeTableItem{
+		{From: null.IntFrom(10 * 60), Type: 1},
+		{From: null.IntFrom(11 * 60), Type: 21},
+	}
+	testPunchSubcommand(t, "in", []timeTableItem{}, http.MethodPut, `"OK"`, "出勤しました :office:")
+	testPunchSubcommand(t, "in", attending, "", "", "既に出勤済です")
+	testPunchSubcommand(t, "out", attending, http.MethodPut, `"OK"`, "退勤しました :house:")
+	testPunchSubcommand(t, "out", resting, "", "", "休憩中です。休憩を終了してから退勤してください")
+	testPunchSubcommand(t, "break", attending, http.MethodPost, `"OK"`, "休憩を開始しました :coffee:")
+	testPunchSubcommand(t, "break", attending, http.MethodPost, `"NG"`, "勤務表の更新に失敗しました :warning:")
+	testPunchSubcommand(t, "break", []timeTableItem{}, "", "", "まだ出勤していません")
+	testPunchSubcommand(t, "back", resting, http.MethodPost, `"OK"`, "休憩を終了しました :computer:")
+	testPunchSubcommand(t, "back", attending, "", "", "休憩中ではありません")
+	testPunchSubcommand(t, "away", attending, http.MethodPost, `"OK"`, "私用外出を開始しました :walking:")
+	testPunchSubcommand(t, "back", []timeTableItem{
+		{From: null.IntFrom(10 * 60), Type: 1},
+		{From: null.IntFrom(11 * 60), Type: 22},
+	}, http.MethodPost, `"OK"`, "私用外出から戻りました :computer:")
+}
+
+func TestPunchSubcommandOvernight(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/slash", bytes.NewBufferString(""))
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	ctx.now = getMockOvernightTime
+	ctx.setSalesforceAccessToken(&oauth2.Token{
+		AccessToken:  "foo",
+		RefreshToken: "bar",
+		TokenType:    "Bearer",
+	})
+	gock.New("https://teamspirit-1234.cloudforce.test").
+		Get("/services/apexrest/Dakoku").
+		MatchParam("date", "2018-08-31").
+		Times(2).
+		Reply(200).
+		JSON(map[string]interface{}{
+			"timeTable": []timeTableItem{{From: null.IntFrom(18 * 60), Type: 1}},
+			"date":      "2018-08-31",
+		})
+	gock.New("https://teamspirit-1234.cloudforce.test").
+		Get("/services/apexrest/Dakoku").
+		Times(2).
+		Reply(200).
+		JSON(map[string]interface{}{
+			"timeTable": []timeTableItem{},
+			"date":      "2018-09-01",
+		})
+	gock.New("https://teamspirit-1234.cloudforce.test").
+		Put("/services/apexrest/Dakoku").
+		JSON(map[string]interface{}{"attendance": false, "minutes": 1632, "date": "2018-08-31"}).
+		Reply(200).
+		BodyString(`"OK"`)
+	gock.New("https://teamspirit-1234.cloudforce.test").
+		Put("/services/apexrest/Dakoku").
+		JSON(map[string]interface{}{"attendance": false, "minutes": 1470, "date": "2018-08-31"}).
+		Reply(200).
+		BodyString(`"OK"`)
+	for _, test := range []struct {
+		text     string
+		expected string
+	}{
+		{"out", "退勤しました :house:"},
+		{"out 0:30", "24:30 に退勤しました :house:"},
+	} {
+		msg, err := ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", UserID: "FOO", Text: test.text})
+		Test{true, err == nil}.Compare(t)
+		Test{test.expected, msg.Text}.Compare(t)
+	}
+	Test{true, gock.IsDone()}.Compare(t)
+}
+
+func TestParsePunchTime(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		from  null.Int
+		to    null.Int
+		err   error
+	}{
+		{"9:05", null.IntFrom(545), null.Int{}, nil},
+		{"09:05", null.IntFrom(545), null.Int{}, nil},
+		{"0:00", null.IntFrom(0), null.Int{}, nil},
+		{"12:00-13:00", null.IntFrom(720), null.IntFrom(780), nil},
+		{"23:59", null.IntFrom(1439), null.Int{}, nil},
+		{"25:30", null.IntFrom(1530), null.Int{}, nil},
+		{"48:00", null.Int{}, null.Int{}, errInvalidPunchTime},
+		{"9:60", null.Int{}, null.Int{}, errInvalidPunchTime},
+		{"9:5", null.Int{}, null.Int{}, errInvalidPunchTime},
+		{"905", null.Int{}, null.Int{}, errInvalidPunchTime},
+		{"foo", null.Int{}, null.Int{}, errInvalidPunchTime},
+		{"12:00-", null.Int{}, null.Int{}, errInvalidPunchTime},
+		{"12:00-13:00-14:00", null.Int{}, null.Int{}, errInvalidPunchTime},
+	} {
+		pt, err := parsePunchTime(test.value)
+		Test{test.err, err}.Compare(t)
+		if err == nil {
+			Test{test.from, pt.From}.Compare(t)
+			Test{test.to, pt.To}.Compare(t)
+		}
+	}
+	Test{"9:05", (&punchTime{From: null.IntFrom(545)}).String()}.Compare(t)
+	Test{"12:00 - 13:00", (&punchTime{From: null.IntFrom(720), To: null.IntFrom(780)}).String()}.Compare(t)
+}
+
+func TestResolvePunchTime(t *testing.T) {
+	for _, test := range []struct {
+		from     int64
+		to       null.Int
+		now      int64
+		expected punchTime
+	}{
+		{545, null.Int{}, 600, punchTime{From: null.IntFrom(545)}},
+		{720, null.IntFrom(780), 600, punchTime{From: null.IntFrom(720), To: null.IntFrom(780)}},
+		{30, null.Int{}, 1530, punchTime{From: null.IntFrom(1470)}},
+		{1410, null.IntFrom(30), 1530, punchTime{From: null.IntFrom(1410), To: null.IntFrom(1470)}},
+		{1530, null.Int{}, 1530, punchTime{From: null.IntFrom(1530)}},
+	} {
+		pt := &punchTime{From: null.IntFrom(test.from), To: test.to}
+		Test{test.expected, *pt.resolve(test.now)}.DeepEqual(t)
+	}
+}
+
+func TestValidatePunchTime(t *testing.T) {
+	tt := &timeTable{Items: []timeTableItem{
+		{From: null.IntFrom(9 * 60), Type: 1},
+		{From: null.IntFrom(12 * 60), To: null.IntFrom(13 * 60), Type: 21},
+		{From: null.IntFrom(15 * 60), Type: 21},
+	}}
+	at := func(from int64) *punchTime {
+		return &punchTime{From: null.IntFrom(from)}
+	}
+	between := func(from, to int64) *punchTime {
+		return &punchTime{From: null.IntFrom(from), To: null.IntFrom(to)}
+	}
+	now := int64(16 * 60)
+	for _, test := range []Test{
+		{"時間帯を指定できるのは `/ts break` と `/ts away` のみです", tt.validatePunchTime(actionTypeAttend, between(540, 600), now)},
+		{"終了時刻は開始時刻より後にしてください", tt.validatePunchTime(actionTypeRest, between(600, 600), now)},
+		{"未来の時刻は指定できません", tt.validatePunchTime(actionTypeLeave, at(17*60), now)},
+		{"未来の時刻は指定できません", tt.validatePunchTime(actionTypeRest, between(15*60+30, 17*60), now)},
+		{"", tt.validatePunchTime(actionTypeAttend, at(8*60), now)},
+		{"出勤時刻は休憩より前にしてください", tt.validatePunchTime(actionTypeAttend, at(12*60+30), now)},
+		{"退勤時刻は出勤時刻より後にしてください", tt.validatePunchTime(actionTypeLeave, at(9*60), now)},
+		{"退勤時刻は休憩より後にしてください", tt.validatePunchTime(actionTypeLeave, at(14*60), now)},
+		{"", tt.validatePunchTime(actionTypeRest, between(10*60, 11*60), now)},
+		{"", tt.validatePunchTime(actionTypeRest, between(13*60, 14*60), now)},
+		{"休憩は出勤時刻より後にしてください", tt.validatePunchTime(actionTypeRest, between(8*60, 10*60), now)},
+		{"既存の休憩と重複しています", tt.validatePunchTime(actionTypeRest, between(11*60, 12*60+30), now)},
+		{"既存の休憩と重複しています", tt.validatePunchTime(actionTypeRest, between(14*60, 15*60+30), now)},
+		{"既存の休憩と重複しています", tt.validatePunchTime(actionTypeRest, at(12*60+30), now)},
+		{"", tt.validatePunchTime(actionTypeUnrest, at(15*60+30), now)},
+		{"終了時刻は開始時刻より後にしてください", tt.validatePunchTime(actionTypeUnrest, at(15*60), now)},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestPunchSubcommandsWithTime(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/slash", bytes.NewBufferString(""))
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	ctx.now = getMockTime
+	ctx.setSalesforceAccessToken(&oauth2.Token{
+		AccessToken:  "foo",
+		RefreshToken: "bar",
+		TokenType:    "Bearer",
+	})
+
+	setupTimeTableGocks([]timeTableItem{}, &[]bool{false}[0])
+	gock.New("https://teamspirit-1234.cloudforce.test").
+		Put("/services/apexrest/Dakoku").
+		JSON(map[string]interface{}{"attendance": true, "minutes": 545}).
+		Reply(200).
+		BodyString(`"OK"`)
+	msg, err := ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", UserID: "FOO", Text: "in 9:05"})
+	for _, test := range []Test{
+		{true, err == nil},
+		{"in_channel", msg.ResponseType},
+		{"9:05 に出勤しました :office:", msg.Text},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+
+	setupTimeTableGocks([]timeTableItem{
+		{From: null.IntFrom(9 * 60), Type: 1},
+	}, &[]bool{false}[0])
+	gock.New("https://teamspirit-1234.cloudforce.test").
+		Post("/services/apexrest/Dakoku").
+		JSON(map[string]interface{}{"timeTable": []map[string]interface{}{
+			{"from": 540, "to": nil, "type": 1},
+			{"from": 600, "to": 630, "type": 21},
+		}}).
+		Reply(200).
+		BodyString(`"OK"`)
+	ctx.TimeTableClient = nil
+	msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", UserID: "FOO", Text: "break 10:00-10:30"})
+	for _, test := range []Test{
+		{true, err == nil},
+		{"10:00 - 10:30 の休憩を登録しました :coffee:", msg.Text},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+
+	for _, test := range []struct {
+		text     string
+		expected string
+	}{
+		{"in 9", "時刻は `9:05` や `12:00-13:00` の形式で指定してください"},
+		{"in 9:05 10:00", "引数が多すぎます。使い方: `/ts in [9:05]`"},
+		{"break 10:00-10:30", "既存の休憩と重複しています"},
+		{"out 12:00", "未来の時刻は指定できません"},
+	} {
+		setupTimeTableGocks([]timeTableItem{
+			{From: null.IntFrom(9 * 60), Type: 1},
+			{From: null.IntFrom(10 * 60), To: null.IntFrom(10*60 + 30), Type: 21},
+		}, &[]bool{false}[0])
+		ctx.TimeTableClient = nil
+		msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", UserID: "FOO", Text: test.text})
+		Test{true, err == nil}.Compare(t)
+		Test{"ephemeral", msg.ResponseType}.Compare(t)
+		Test{test.expected, msg.Text}.Compare(t)
+	}
+}
+
+func TestUpdateTimeTableConflict(t *testing.T) {
+	app := createMockApp()
+	ctx := app.createContext(nil)
+	posted := []timeTable{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"timeTable":[{"from":600,"type":1},{"from":720,"to":780,"type":21}],"lastModifiedDate":"2018-09-01 11:00:00"}`))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var tt timeTable
+		json.Unmarshal(body, &tt)
+		posted = append(posted, tt)
+		if tt.LastModifiedDate != "2018-09-01 11:00:00" {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`"CONFLICT"`))
+			return
+		}
+		w.Write([]byte(`"OK"`))
+	}))
+	defer server.Close()
+	client := &timeTableClient{
+		HTTPClient:    &http.Client{Transport: &http.Transport{}},
+		Endpoint:      server.URL,
+		MaxRetries:    2,
+		RetryInterval: time.Millisecond,
+	}
+	tt := &timeTable{
+		Items:            []timeTableItem{{From: null.IntFrom(600), Type: 1}},
+		LastModifiedDate: "2018-09-01 10:00:00",
+	}
+	ok, err := ctx.punch(context.Background(), client, tt, actionTypeRest, getMockTime())
+	for _, test := range []Test{
+		{true, ok},
+		{true, err == nil},
+		{2, len(posted)},
+		{"2018-09-01 10:00:00", posted[0].LastModifiedDate},
+		{2, len(posted[0].Items)},
+		{"2018-09-01 11:00:00", posted[1].LastModifiedDate},
+		{3, len(posted[1].Items)},
+		{int64(720), posted[1].Items[1].From.Int64},
+		{int64(672), posted[1].Items[2].From.Int64},
+		{false, posted[1].Items[2].To.Valid},
+		{3, len(tt.Items)},
+	} {
+		test.Compare(t)
+	}
+
+	posted = []timeTable{}
+	tt = &timeTable{
+		Items: []timeTableItem{
+			{From: null.IntFrom(600), Type: 1},
+			{From: null.IntFrom(720), Type: 21},
+		},
+		LastModifiedDate: "2018-09-01 10:00:00",
+	}
+	ok, err = ctx.punch(context.Background(), client, tt, actionTypeUnrest, getMockTime())
+	for _, test := range []Test{
+		{true, ok},
+		{true, err == nil},
+		{1, len(posted)},
+		{int64(780), tt.Items[1].To.Int64},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestGetPunchText(t *testing.T) {
+	resting := &timeTable{Items: []timeTableItem{
+		{From: null.IntFrom(10 * 60), Type: 1},
+		{From: null.IntFrom(12 * 60), Type: 21},
+	}}
+	away := &timeTable{Items: []timeTableItem{
+		{From: null.IntFrom(10 * 60), Type: 1},
+		{From: null.IntFrom(12 * 60), Type: 22},
+	}}
+	at := &punchTime{From: null.IntFrom(13 * 60)}
+	between := &punchTime{From: null.IntFrom(12 * 60), To: null.IntFrom(13 * 60)}
+	for _, test := range []Test{
+		{"出勤しました :office:", getPunchText(resting, actionTypeAttend, nil)},
+		{"13:00 に出勤しました :office:", getPunchText(resting, actionTypeAttend, at)},
+		{"休憩を終了しました :computer:", getPunchText(resting, actionTypeUnrest, nil)},
+		{"13:00 に休憩を終了しました :computer:", getPunchText(resting, actionTypeUnrest, at)},
+		{"私用外出から戻りました :computer:", getPunchText(away, actionTypeUnrest, nil)},
+		{"13:00 に私用外出から戻りました :computer:", getPunchText(away, actionTypeUnrest, at)},
+		{"私用外出を開始しました :walking:", getPunchText(resting, actionTypeAway, nil)},
+		{"13:00 に私用外出を開始しました :walking:", getPunchText(resting, actionTypeAway, at)},
+		{"12:00 - 13:00 の私用外出を登録しました :walking:", getPunchText(resting, actionTypeAway, between)},
+		{"12:00 - 13:00 の休憩を登録しました :coffee:", getPunchText(resting, actionTypeRest, between)},
+	} {
+		test.Compare(t)
+	}
+}
@@ -1,45 +1,108 @@
 package app
 
 import (
-	"os"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
 
-func (app *App) reconnectRedisIfNeeeded() {
-	res, _ := app.RedisConn.Do("PING")
-	if pong, ok := res.([]byte); !ok || string(pong) != "PONG" {
-		err := app.setupRedis()
-		if err != nil {
-			panic(err)
-		}
+type redisStore struct {
+	Pool *redis.Pool
+}
+
+func newRedisStore(url string) (*redisStore, error) {
+	store := &redisStore{
+		Pool: &redis.Pool{
+			MaxIdle:     10,
+			MaxActive:   100,
+			IdleTimeout: 4 * time.Minute,
+			Wait:        true,
+			Dial: func() (redis.Conn, error) {
+				return dialRedis(url)
+			},
+			TestOnBorrow: func(conn redis.Conn, t time.Time) error {
+				if time.Since(t) < time.Minute {
+					return nil
+				}
+				_, err := conn.Do("PING")
+				return err
+			},
+		},
+	}
+	if err := store.Ping(); err != nil {
+		store.Pool.Close()
+		return nil, err
 	}
+	return store, nil
 }
 
-func (app *App) setupRedis() error {
+func dialRedis(url string) (redis.Conn, error) {
 	connectTimeout := 1 * time.Second
 	readTimeout := 1 * time.Second
 	writeTimeout := 1 * time.Second
 
-	if url := os.Getenv("REDIS_URL"); url != "" {
-		conn, err := redis.DialURL(url,
+	if url != "" {
+		return redis.DialURL(url,
 			redis.DialConnectTimeout(connectTimeout),
 			redis.DialReadTimeout(readTimeout),
 			redis.DialWriteTimeout(writeTimeout))
-		if err != nil {
-			return err
-		}
-		app.RedisConn = conn
-		return nil
 	}
-	conn, err := redis.Dial("tcp", ":6379",
+	return redis.Dial("tcp", ":6379",
 		redis.DialConnectTimeout(connectTimeout),
 		redis.DialReadTimeout(readTimeout),
 		redis.DialWriteTimeout(writeTimeout))
+}
+
+func (s *redisStore) do(commandName string, args ...interface{}) (interface{}, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+	return conn.Do(commandName, args...)
+}
+
+func (s *redisStore) Ping() error {
+	_, err := s.do("PING")
+	return err
+}
+
+func (s *redisStore) Get(hashKey, field string) (string, error) {
+	res, err := redis.String(s.do("HGET", hashKey, field))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return res, err
+}
+
+func (s *redisStore) Set(hashKey, field, value string) error {
+	_, err := s.do("HSET", hashKey, field, value)
+	return err
+}
+
+func (s *redisStore) SetIfAbsent(hashKey, field, value string) (bool, error) {
+	return redis.Bool(s.do("HSETNX", hashKey, field, value))
+}
+
+func (s *redisStore) Delete(hashKey, field string) error {
+	_, err := s.do("HDEL", hashKey, field)
+	return err
+}
+
+func (s *redisStore) Take(hashKey, field string) (string, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+	conn.Send("MULTI")
+	conn.Send("HGET", hashKey, field)
+	conn.Send("HDEL", hashKey, field)
+	values, err := redis.Values(conn.Do("EXEC"))
 	if err != nil {
-		return err
+		return "", err
+	}
+	res, err := redis.String(values[0], nil)
+	if err == redis.ErrNil {
+		return "", nil
 	}
-	app.RedisConn = conn
-	return nil
+	return res, err
+}
+
+func (s *redisStore) All(hashKey string) (map[string]string, error) {
+	return redis.StringMap(s.do("HGETALL", hashKey))
 }
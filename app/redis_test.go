@@ -0,0 +1,13 @@
+package app
+
+import (
+	"testing"
+)
+
+func TestRedisStore(t *testing.T) {
+	store, err := newRedisStore("")
+	if err != nil {
+		t.Skip(err.Error())
+	}
+	testStore(t, store)
+}
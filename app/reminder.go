@@ -0,0 +1,137 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// reminderWindow is the minutes after the reminder time in which a reminder missed by the scheduler is still sent
+const reminderWindow = 60
+
+// reminder is the clock-in reminder setting of a user
+type reminder struct {
+	TeamID string `json:"teamId"`
+	// Minutes is the time of the reminder in minutes from 00:00 in the time zone of the user
+	Minutes int64 `json:"minutes"`
+	// CheckedOn is the last date the time table was checked, to send the reminder once a day
+	CheckedOn string `json:"checkedOn,omitempty"`
+}
+
+func (ctx *Context) getReminderForUser() *reminder {
+	value, _ := ctx.getVariableInHash(ctx.ReminderStoreKey, ctx.UserID)
+	if value == "" {
+		return nil
+	}
+	var r reminder
+	if json.Unmarshal([]byte(value), &r) != nil {
+		return nil
+	}
+	return &r
+}
+
+func (ctx *Context) setReminder(r *reminder) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return ctx.setVariableInHash(ctx.ReminderStoreKey, string(b))
+}
+
+// isDue returns true if the reminder is not checked yet today and now is within reminderWindow from its time
+func (r *reminder) isDue(now time.Time) bool {
+	minutes := convertTime(now).Int64
+	return r.CheckedOn != now.Format(timeTableDateLayout) && minutes >= r.Minutes && minutes < r.Minutes+reminderWindow
+}
+
+// sendReminders sends the clock-in reminders which are due
+func (app *App) sendReminders(now time.Time) {
+	reminders, err := app.Store.All(app.ReminderStoreKey)
+	if err != nil {
+		fmt.Printf("Load Reminders Error: %+v\n", err.Error())
+		return
+	}
+	forEachUser(reminders, func(userID string) {
+		ctx := app.createUserContext("", userID, now)
+		if err := ctx.sendReminder(); err != nil {
+			fmt.Printf("Send Reminder Error: %+v\n", err.Error())
+		}
+	})
+}
+
+// sendReminder sends a DM to the user if the reminder is due and the user has not clocked in on a working day
+func (ctx *Context) sendReminder() error {
+	r := ctx.getReminderForUser()
+	if r == nil {
+		return nil
+	}
+	ctx.TeamID = r.TeamID
+	now := ctx.localNow()
+	if !r.isDue(now) {
+		return nil
+	}
+	reqCtx, cancel := ctx.newRequestContext()
+	defer cancel()
+	client, err := ctx.createTimeTableClient(reqCtx)
+	if err != nil {
+		return err
+	}
+	// failures are retried on the next tick within reminderWindow
+	tt, err := client.GetTimeTableOn(reqCtx, now.Format(timeTableDateLayout))
+	if err != nil {
+		return err
+	}
+	if !tt.IsAttending() && (tt.IsHoliday == nil || !*tt.IsHoliday) {
+		if err := ctx.sendDirectMessage(getReminderDirectMessage()); err != nil {
+			return err
+		}
+	}
+	r.CheckedOn = now.Format(timeTableDateLayout)
+	return ctx.setReminder(r)
+}
+
+func getReminderDirectMessage() *message {
+	text := "まだ出勤の打刻がされていません :alarm_clock:"
+	return &message{
+		Msg: slack.Msg{Text: text},
+		Blocks: []block{
+			sectionBlock(text),
+			actionsBlock(callbackIDAttendanceButton,
+				buttonElement(actionTypeAttend, "出勤する", "primary"),
+			),
+		},
+	}
+}
+
+func (ctx *Context) getRemindSlackMessage(input *commandInput) (*message, error) {
+	if len(input.Args) == 0 {
+		text := "リマインダーは設定されていません"
+		if r := ctx.getReminderForUser(); r != nil {
+			text = "勤務日の " + formatMinutes(r.Minutes) + " に出勤していなければ DM でお知らせします"
+		}
+		return getEphemeralSlackMessage(text), nil
+	}
+	if input.Args[0] == "off" {
+		if err := ctx.deleteVariableInHash(ctx.ReminderStoreKey); err != nil {
+			return nil, err
+		}
+		return getEphemeralSlackMessage("リマインダーを解除しました :no_bell:"), nil
+	}
+	minutes, err := parseClock(input.Args[0])
+	if err != nil || minutes >= 24*60 {
+		return getErrorSlackMessage("時刻は `9:30` の形式で指定してください"), nil
+	}
+	token, err := ctx.getSlackAPIToken()
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return ctx.getAuthenticateSlackMessage(input.State, input.Name)
+	}
+	if err := ctx.setReminder(&reminder{TeamID: input.State.TeamID, Minutes: minutes}); err != nil {
+		return nil, err
+	}
+	return getEphemeralSlackMessage("勤務日の " + formatMinutes(minutes) + " に出勤していなければ DM でお知らせします :alarm_clock:"), nil
+}
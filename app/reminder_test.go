@@ -0,0 +1,171 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	null "gopkg.in/guregu/null.v3"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func TestReminderIsDue(t *testing.T) {
+	now := getMockTime()
+	for _, test := range []Test{
+		{true, (&reminder{Minutes: 660}).isDue(now)},
+		{true, (&reminder{Minutes: 672}).isDue(now)},
+		{false, (&reminder{Minutes: 673}).isDue(now)},
+		{false, (&reminder{Minutes: 600}).isDue(now)},
+		{false, (&reminder{Minutes: 660, CheckedOn: "2018-09-01"}).isDue(now)},
+		{true, (&reminder{Minutes: 660, CheckedOn: "2018-08-31"}).isDue(now)},
+	} {
+		test.Compare(t)
+	}
+}
+
+func createReminderTestContext(app *App) *Context {
+	ctx := createLoggedInTestContext(app, getMockTime())
+	ctx.setReminder(&reminder{TeamID: "T12345678", Minutes: 660})
+	return ctx
+}
+
+func TestSendReminders(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := createReminderTestContext(app)
+
+	setupTimeTableGocks([]timeTableItem{}, &[]bool{false}[0])
+	setupDirectMessageGock("", "baz")
+	app.sendReminders(getMockTime())
+	for _, test := range []Test{
+		{true, gock.IsDone()},
+		{"2018-09-01", ctx.getReminderForUser().CheckedOn},
+	} {
+		test.Compare(t)
+	}
+
+	// checked once a day
+	app.sendReminders(getMockTime().Add(time.Minute))
+	Test{"2018-09-01", ctx.getReminderForUser().CheckedOn}.Compare(t)
+}
+
+func TestSendReminderSkipped(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	for _, items := range [][]timeTableItem{
+		{{From: null.IntFrom(600), Type: 1}},
+		{},
+	} {
+		app.CleanStore()
+		ctx := createReminderTestContext(app)
+		setupTimeTableGocks(items, &[]bool{len(items) == 0}[0])
+		for _, test := range []Test{
+			{nil, ctx.sendReminder()},
+			{true, gock.IsDone()},
+			{"2018-09-01", ctx.getReminderForUser().CheckedOn},
+		} {
+			test.Compare(t)
+		}
+	}
+}
+
+func TestSendReminderRetried(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := createReminderTestContext(app)
+	ctx.TimeTableClient = &timeTableClient{
+		HTTPClient: http.DefaultClient,
+		Endpoint:   "https://teamspirit-1234.cloudforce.test/services/apexrest/Dakoku",
+	}
+	gock.New("https://teamspirit-1234.cloudforce.test").
+		Get("/services/apexrest/Dakoku").
+		Reply(503)
+	for _, test := range []Test{
+		{true, ctx.sendReminder() != nil},
+		{"", ctx.getReminderForUser().CheckedOn},
+	} {
+		test.Compare(t)
+	}
+
+	// sent on the next tick
+	setupTimeTableGocks([]timeTableItem{}, &[]bool{false}[0])
+	setupDirectMessageGock("", "baz")
+	app.sendReminders(getMockTime().Add(time.Minute))
+	for _, test := range []Test{
+		{true, gock.IsDone()},
+		{"2018-09-01", ctx.getReminderForUser().CheckedOn},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestSendReminderInTimeZone(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := createReminderTestContext(app)
+	ctx.setTimeZone("Pacific/Honolulu")
+	ctx.setReminder(&reminder{TeamID: "T12345678", Minutes: 960})
+
+	// 2018-09-01 11:12 in Tokyo is 2018-08-31 16:12 in Honolulu
+	gock.New("https://teamspirit-1234.cloudforce.test").
+		Get("/services/apexrest/Dakoku").
+		MatchParam("date", "2018-08-31").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"timeTable": []timeTableItem{},
+			"isHoliday": false,
+		})
+	setupDirectMessageGock("", "baz")
+	for _, test := range []Test{
+		{nil, ctx.sendReminder()},
+		{true, gock.IsDone()},
+		{"2018-08-31", ctx.getReminderForUser().CheckedOn},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestRemindSubcommand(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/slash", nil)
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+
+	msg, err := ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", UserID: "FOO", Text: "remind 9:30"})
+	for _, test := range []Test{
+		{true, err == nil},
+		{"Slack で認証を行って、再度 `/ts remind` コマンドを実行してください :bow:", msg.Blocks[0].Text.Text},
+	} {
+		test.Compare(t)
+	}
+
+	ctx.setSlackAccessToken("baz")
+	for _, test := range []struct {
+		text     string
+		expected string
+	}{
+		{"remind", "リマインダーは設定されていません"},
+		{"remind 9:3", "時刻は `9:30` の形式で指定してください"},
+		{"remind 25:00", "時刻は `9:30` の形式で指定してください"},
+		{"remind 9:30", "勤務日の 9:30 に出勤していなければ DM でお知らせします :alarm_clock:"},
+		{"remind", "勤務日の 9:30 に出勤していなければ DM でお知らせします"},
+		{"remind off", "リマインダーを解除しました :no_bell:"},
+		{"remind", "リマインダーは設定されていません"},
+	} {
+		msg, err := ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", UserID: "FOO", Text: test.text})
+		for _, test := range []Test{
+			{true, err == nil},
+			{"ephemeral", msg.ResponseType},
+			{test.expected, msg.Text},
+		} {
+			test.Compare(t)
+		}
+	}
+	ctx.setReminder(&reminder{TeamID: "T12345678", Minutes: 570})
+	Test{"T12345678", ctx.getReminderForUser().TeamID}.Compare(t)
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultTimeTableMaxRetries    = 2
+	defaultTimeTableRetryInterval = 500 * time.Millisecond
+)
+
+// isRetryableError returns true if the request may succeed when it is sent again
+func isRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == errTimeout {
+		return true
+	}
+	if apiErr, ok := err.(*timeTableError); ok {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	_, ok := err.(*url.Error)
+	return ok
+}
+
+// getRetryDelay returns the exponential backoff with equal jitter for the attempt
+func (client *timeTableClient) getRetryDelay(attempt int) time.Duration {
+	max := client.RetryInterval << uint(attempt)
+	if max <= 0 {
+		return 0
+	}
+	return max/2 + time.Duration(rand.Int63n(int64(max/2)+1))
+}
+
+func (client *timeTableClient) waitForRetry(reqCtx context.Context, attempt int) error {
+	timer := time.NewTimer(client.getRetryDelay(attempt))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-reqCtx.Done():
+		return errTimeout
+	}
+}
+
+// retry calls update until it succeeds or fails with a non retryable error.
+// Because a failed request may still have been applied by TeamSpirit, the time table is fetched before
+// each retry and update is not called again if landed reports the change is already there.
+// date is the work day of the time table to be fetched, or empty for today.
+func (client *timeTableClient) retry(reqCtx context.Context, date string, update func() (bool, error), landed func(tt *timeTable) bool) (bool, error) {
+	ok, err := update()
+	for attempt := 0; attempt < client.MaxRetries && isRetryableError(err); attempt++ {
+		if err := client.waitForRetry(reqCtx, attempt); err != nil {
+			return false, err
+		}
+		tt, getErr := client.GetTimeTableOn(reqCtx, date)
+		if getErr != nil {
+			// it is unknown whether the update was applied, so do not send it again
+			err = getErr
+			continue
+		}
+		if landed(tt) {
+			return true, nil
+		}
+		ok, err = update()
+	}
+	return ok, err
+}
@@ -0,0 +1,222 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	null "gopkg.in/guregu/null.v3"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	for _, test := range []Test{
+		{false, isRetryableError(nil)},
+		{true, isRetryableError(errTimeout)},
+		{false, isRetryableError(errUnauthorized)},
+		{false, isRetryableError(errRefreshTokenRevoked)},
+		{false, isRetryableError(errors.New("foo"))},
+		{true, isRetryableError(&url.Error{Op: "Get", URL: "https://example.com", Err: errors.New("connection reset by peer")})},
+		{true, isRetryableError(&timeTableError{Code: httpErrorCode, StatusCode: http.StatusServiceUnavailable})},
+		{true, isRetryableError(&timeTableError{Code: httpErrorCode, StatusCode: http.StatusBadGateway})},
+		{true, isRetryableError(&timeTableError{Code: httpErrorCode, StatusCode: http.StatusGatewayTimeout})},
+		{true, isRetryableError(&timeTableError{Code: httpErrorCode, StatusCode: http.StatusTooManyRequests})},
+		{false, isRetryableError(&timeTableError{Code: "APEX_ERROR", StatusCode: http.StatusInternalServerError})},
+		{false, isRetryableError(&timeTableError{Code: "INVALID_SESSION_ID"})},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestGetRetryDelay(t *testing.T) {
+	client := &timeTableClient{RetryInterval: 100 * time.Millisecond}
+	for attempt, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		for i := 0; i < 20; i++ {
+			delay := client.getRetryDelay(attempt)
+			Test{true, delay >= max/2 && delay <= max}.Compare(t)
+		}
+	}
+	client.RetryInterval = 0
+	Test{time.Duration(0), client.getRetryDelay(1)}.Compare(t)
+}
+
+type retryTestServer struct {
+	*httptest.Server
+	Responses map[string][]int
+	TimeTable string
+	Requests  map[string]int
+}
+
+func newRetryTestServer(timeTable string, responses map[string][]int) *retryTestServer {
+	server := &retryTestServer{
+		Responses: responses,
+		TimeTable: timeTable,
+		Requests:  map[string]int{},
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := server.Requests[r.Method]
+		server.Requests[r.Method]++
+		status := http.StatusOK
+		if statuses := server.Responses[r.Method]; count < len(statuses) {
+			status = statuses[count]
+		}
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			w.Write([]byte(`[{"message":"Service Unavailable","errorCode":"SERVER_UNAVAILABLE"}]`))
+		} else if r.Method == http.MethodGet {
+			w.Write([]byte(server.TimeTable))
+		} else {
+			w.Write([]byte(`"OK"`))
+		}
+	}))
+	return server
+}
+
+func (server *retryTestServer) createClient() *timeTableClient {
+	return &timeTableClient{
+		HTTPClient:    &http.Client{Transport: &http.Transport{}},
+		Endpoint:      server.URL,
+		MaxRetries:    2,
+		RetryInterval: time.Millisecond,
+	}
+}
+
+func TestRetryConfirmsLandedPunch(t *testing.T) {
+	app := createMockApp()
+	ctx := app.createContext(nil)
+
+	server := newRetryTestServer(`{"timeTable":[{"from":600,"type":1}]}`, map[string][]int{
+		http.MethodPut: {http.StatusServiceUnavailable},
+	})
+	defer server.Close()
+	ok, err := ctx.punch(context.Background(), server.createClient(), &timeTable{}, actionTypeAttend, getMockTime())
+	for _, test := range []Test{
+		{true, ok},
+		{true, err == nil},
+		{1, server.Requests[http.MethodPut]},
+		{1, server.Requests[http.MethodGet]},
+	} {
+		test.Compare(t)
+	}
+
+	server = newRetryTestServer(`{"timeTable":[{"from":600,"type":1}]}`, map[string][]int{
+		http.MethodPost: {http.StatusServiceUnavailable},
+	})
+	defer server.Close()
+	tt := &timeTable{Items: []timeTableItem{{From: null.IntFrom(600), Type: 1}}}
+	ok, err = ctx.punch(context.Background(), server.createClient(), tt, actionTypeRest, getMockTime())
+	for _, test := range []Test{
+		{true, ok},
+		{true, err == nil},
+		{2, server.Requests[http.MethodPost]},
+		{1, server.Requests[http.MethodGet]},
+		{2, len(tt.Items)},
+	} {
+		test.Compare(t)
+	}
+
+	server = newRetryTestServer(`{"timeTable":[{"from":600,"type":1},{"from":720,"to":780,"type":21}]}`, map[string][]int{
+		http.MethodPost: {http.StatusBadGateway},
+	})
+	defer server.Close()
+	tt = &timeTable{Items: []timeTableItem{{From: null.IntFrom(600), Type: 1}}}
+	ok, err = ctx.punchAt(context.Background(), server.createClient(), tt, actionTypeRest, &punchTime{From: null.IntFrom(720), To: null.IntFrom(780)})
+	for _, test := range []Test{
+		{true, ok},
+		{true, err == nil},
+		{1, server.Requests[http.MethodPost]},
+		{1, server.Requests[http.MethodGet]},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	app := createMockApp()
+	ctx := app.createContext(nil)
+
+	server := newRetryTestServer(`{"timeTable":[]}`, map[string][]int{
+		http.MethodPut: {http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+	})
+	defer server.Close()
+	ok, err := ctx.punch(context.Background(), server.createClient(), &timeTable{}, actionTypeAttend, getMockTime())
+	for _, test := range []Test{
+		{false, ok},
+		{http.StatusServiceUnavailable, err.(*timeTableError).StatusCode},
+		{3, server.Requests[http.MethodPut]},
+		{2, server.Requests[http.MethodGet]},
+	} {
+		test.Compare(t)
+	}
+
+	server = newRetryTestServer(`{"timeTable":[]}`, map[string][]int{
+		http.MethodPut: {http.StatusServiceUnavailable},
+		http.MethodGet: {http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+	})
+	defer server.Close()
+	ok, err = ctx.punch(context.Background(), server.createClient(), &timeTable{}, actionTypeLeave, getMockTime())
+	for _, test := range []Test{
+		{false, ok},
+		{"SERVER_UNAVAILABLE", err.(*timeTableError).Code},
+		{1, server.Requests[http.MethodPut]},
+		{2, server.Requests[http.MethodGet]},
+	} {
+		test.Compare(t)
+	}
+
+	server = newRetryTestServer(`{"timeTable":[]}`, map[string][]int{
+		http.MethodPost: {http.StatusInternalServerError},
+	})
+	defer server.Close()
+	ok, err = ctx.punch(context.Background(), server.createClient(), &timeTable{}, actionTypeUnrest, getMockTime())
+	for _, test := range []Test{
+		{false, ok},
+		{1, server.Requests[http.MethodPost]},
+		{0, server.Requests[http.MethodGet]},
+	} {
+		test.Compare(t)
+	}
+
+	server = newRetryTestServer(`{"timeTable":[]}`, map[string][]int{
+		http.MethodPut: {http.StatusServiceUnavailable},
+	})
+	defer server.Close()
+	client := server.createClient()
+	client.RetryInterval = time.Hour
+	reqCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ok, err = ctx.punch(reqCtx, client, &timeTable{}, actionTypeAttend, getMockTime())
+	for _, test := range []Test{
+		{false, ok},
+		{errTimeout, err},
+		{0, server.Requests[http.MethodGet]},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestGetPunchLanded(t *testing.T) {
+	tt := &timeTable{Items: []timeTableItem{
+		{From: null.IntFrom(545), To: null.IntFrom(1110), Type: 1},
+		{From: null.IntFrom(720), To: null.IntFrom(780), Type: 21},
+	}}
+	for _, test := range []Test{
+		{true, getPunchLanded(actionTypeAttend, nil)(tt)},
+		{true, getPunchLanded(actionTypeAttend, &punchTime{From: null.IntFrom(545)})(tt)},
+		{false, getPunchLanded(actionTypeAttend, &punchTime{From: null.IntFrom(540)})(tt)},
+		{true, getPunchLanded(actionTypeLeave, nil)(tt)},
+		{true, getPunchLanded(actionTypeLeave, &punchTime{From: null.IntFrom(1110)})(tt)},
+		{false, getPunchLanded(actionTypeLeave, nil)(&timeTable{})},
+		{false, getPunchLanded(actionTypeRest, nil)(tt)},
+		{true, getPunchLanded(actionTypeRest, &punchTime{From: null.IntFrom(720), To: null.IntFrom(780)})(tt)},
+		{false, getPunchLanded(actionTypeAway, &punchTime{From: null.IntFrom(720), To: null.IntFrom(780)})(tt)},
+		{false, getPunchLanded(actionTypeRest, &punchTime{From: null.IntFrom(720)})(tt)},
+		{true, getPunchLanded(actionTypeUnrest, nil)(tt)},
+		{false, getPunchLanded("foo", nil)(tt)},
+	} {
+		test.Compare(t)
+	}
+}
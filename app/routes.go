@@ -22,8 +22,9 @@ func (app *App) setupRouter() *mux.Router {
 	router.HandleFunc("/oauth/salesforce/authenticate/{state}", app.handleSalesforceAuthenticate).Methods(http.MethodGet)
 	router.HandleFunc("/oauth/slack/callback", app.handleSlackOAuthCallback).Methods(http.MethodGet)
 	router.HandleFunc("/oauth/slack/authenticate/{team}/{state}", app.handleSlackAuthenticate).Methods(http.MethodGet)
-	router.HandleFunc("/hooks/slash", app.handleSlashCommand).Methods(http.MethodPost)
-	router.HandleFunc("/hooks/interactive", app.handleActionCallback).Methods(http.MethodPost)
+	router.HandleFunc("/hooks/slash", app.verifySlackRequest(app.handleSlashCommand)).Methods(http.MethodPost)
+	router.HandleFunc("/hooks/interactive", app.verifySlackRequest(app.handleActionCallback)).Methods(http.MethodPost)
+	router.HandleFunc("/hooks/events", app.verifySlackRequest(app.handleEvent)).Methods(http.MethodPost)
 	return router
 }
 
@@ -49,12 +50,15 @@ func (app *App) handleAsset(filename string, w http.ResponseWriter, r *http.Requ
 }
 
 func (app *App) handleSlackAuthenticate(w http.ResponseWriter, r *http.Request) {
-	app.reconnectRedisIfNeeeded()
 	vars := mux.Vars(r)
 	stateKey := vars["state"]
 	team := vars["team"]
 	ctx := app.createContext(r)
-	state := ctx.getState(stateKey)
+	state, err := ctx.getState(stateKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if state == nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -63,7 +67,7 @@ func (app *App) handleSlackAuthenticate(w http.ResponseWriter, r *http.Request)
 		"client_id":    []string{app.SlackClientID},
 		"redirect_uri": []string{ctx.getSlackOAuthCallbackURL()},
 		"state":        []string{stateKey},
-		"scope":        []string{"chat:write:user"},
+		"scope":        []string{"chat:write:user,users:read"},
 		"team":         []string{team},
 	}
 	url := "https://slack.com/oauth/authorize?" + q.Encode()
@@ -71,39 +75,60 @@ func (app *App) handleSlackAuthenticate(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *App) handleSlackOAuthCallback(w http.ResponseWriter, r *http.Request) {
-	app.reconnectRedisIfNeeeded()
 	code := r.URL.Query().Get("code")
 	stateKey := r.URL.Query().Get("state")
 	ctx := app.createContext(r)
+	state, err := ctx.consumeState(stateKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if state == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 	redirectURL := ctx.getSlackOAuthCallbackURL()
 	token, _, err := slack.GetOAuthToken(app.SlackClientID, app.SlackClientSecret, code, redirectURL, false)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	state := ctx.getState(stateKey)
 	ctx.UserID = state.UserID
-	ctx.setSlackAccessToken(token)
-	ctx.deleteState(stateKey)
+	if err := ctx.setSlackAccessToken(token); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	ctx.resetTimeZoneError()
 	go func() {
 		params, _ := ctx.getChannelSelectSlackMessage()
 		params.Text = "認証が完了しました :white_check_mark:"
-		b, _ := json.Marshal(params)
-		http.Post(state.ResponseURL, "application/json", bytes.NewBuffer(b))
+		params.Blocks = append([]block{sectionBlock(params.Text)}, params.Blocks...)
+		if state.ResponseURL == "" {
+			// the login started from a DM to the bot has no response_url
+			if err := ctx.sendDirectMessage(params); err != nil {
+				fmt.Printf("Send Direct Message Error: %+v\n", err.Error())
+			}
+			return
+		}
+		postToResponseURL(state.ResponseURL, params)
 	}()
 	http.Redirect(w, r, "/success", http.StatusFound)
 }
 
 func (app *App) handleSalesforceAuthenticate(w http.ResponseWriter, r *http.Request) {
-	app.reconnectRedisIfNeeeded()
 	vars := mux.Vars(r)
 	stateKey := vars["state"]
 	ctx := app.createContext(r)
-	state := ctx.getState(stateKey)
+	state, err := ctx.getState(stateKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if state == nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	ctx.TeamID = state.TeamID
 	config := ctx.getSalesforceOAuth2Config()
 	config.Scopes = []string{"refresh_token", "full"}
 	url := config.AuthCodeURL(stateKey, oauth2.AccessTypeOffline)
@@ -111,23 +136,38 @@ func (app *App) handleSalesforceAuthenticate(w http.ResponseWriter, r *http.Requ
 }
 
 func (app *App) handleSalesforceOAuthCallback(w http.ResponseWriter, r *http.Request) {
-	app.reconnectRedisIfNeeeded()
 	code := r.URL.Query().Get("code")
 	stateKey := r.URL.Query().Get("state")
 	ctx := app.createContext(r)
+	state, err := ctx.consumeState(stateKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if state == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	ctx.UserID = state.UserID
+	ctx.TeamID = state.TeamID
 	token, err := ctx.getSalesforceAccessToken(code, stateKey)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	state := ctx.getState(stateKey)
-	ctx.UserID = state.UserID
-	ctx.setSalesforceAccessToken(token)
-	http.Redirect(w, r, ctx.getSlackAuthenticateURL(state.TeamID, stateKey), http.StatusFound)
+	if err := ctx.setSalesforceAccessToken(token); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	slackStateKey, err := ctx.storeState(*state)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Redirect(w, r, ctx.getSlackAuthenticateURL(state.TeamID, slackStateKey), http.StatusFound)
 }
 
 func (app *App) handleSlashCommand(w http.ResponseWriter, r *http.Request) {
-	app.reconnectRedisIfNeeeded()
 	s, err := slack.SlashCommandParse(r)
 
 	if err != nil {
@@ -135,18 +175,24 @@ func (app *App) handleSlashCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !s.ValidateToken(app.SlackVerificationToken) {
+	if app.SlackSigningSecret == "" && !s.ValidateToken(app.SlackVerificationToken) {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
 	ctx := app.createContext(r)
 	ctx.UserID = s.UserID
+	ctx.TeamID = s.TeamID
 
 	go func() {
-		params, _ := ctx.getSlackMessage(s)
-		b, _ := json.Marshal(params)
-		http.Post(s.ResponseURL, "application/json", bytes.NewBuffer(b))
+		params, err := ctx.getSlackMessage(s)
+		if err != nil {
+			fmt.Printf("Handle Slash Command Error: %+v\n", err.Error())
+		}
+		if params == nil {
+			params = getErrorSlackMessage(getTimeTableErrorText(err))
+		}
+		postToResponseURL(s.ResponseURL, params)
 	}()
 
 	w.Header().Set("Content-Type", "text/plain")
@@ -154,52 +200,83 @@ func (app *App) handleSlashCommand(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *App) handleActionCallback(w http.ResponseWriter, r *http.Request) {
-	app.reconnectRedisIfNeeeded()
 	ctx := app.createContext(r)
 	r.ParseForm()
 	payload := r.PostForm.Get("payload")
 
-	var data slack.AttachmentActionCallback
-	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+	data, err := parseActionCallback([]byte(payload))
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if data.Token != ctx.SlackVerificationToken {
+	if app.SlackSigningSecret == "" && data.Token != ctx.SlackVerificationToken {
 		http.Error(w, "Invlaid token", http.StatusUnauthorized)
 		return
 	}
-	ctx.UserID = data.User.ID
+	ctx.UserID = data.UserID
+	ctx.TeamID = data.TeamID
 	if data.CallbackID == callbackIDChannelSelect {
-		action := data.Actions[0]
 		channelID := ""
 		text := "通知を止めました :no_bell:"
-		if action.Name == actionTypeSelectChannel {
-			opt := action.SelectedOptions[0]
-			channelID = opt.Value
+		if data.ActionName == actionTypeSelectChannel {
+			channelID = data.SelectedValue
 			text = "<#" + channelID + "> に通知します :mega:"
 		}
-		ctx.setVariableInHash(ctx.NotifyChannelStoreKey, channelID)
-		w.Header().Set("Content-Type", "text/plain")
-		w.Write([]byte(text))
+		if err := ctx.setVariableInHash(ctx.NotifyChannelStoreKey, channelID); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		replyToAction(w, data, text)
+		return
+	}
+	if data.ActionName == actionTypeSnoozeLeave {
+		text, err := ctx.snoozeLeaveNudge()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		replyToAction(w, data, text)
+		return
+	}
+	if !isPunchAction(data.ActionName) {
+		// URL buttons such as authenticate only need an acknowledgement
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 	go func() {
-		params, responseURL, err := ctx.getActionCallback(&data)
+		params, responseURL, err := ctx.getActionCallback(data)
 		if err != nil && params == nil && responseURL != "" {
-			http.Post(responseURL, "text/plain", bytes.NewBufferString(err.Error()))
+			slackHTTPClient.Post(responseURL, "text/plain", bytes.NewBufferString(err.Error()))
 			return
 		} else if err != nil {
 			fmt.Printf("Handle Action Callback Error: %+v\n", err.Error())
 		}
-		slackToken := ctx.getSlackAccessTokenForUser()
-		slackChannel := ctx.getSlackNotifyChannelForUser()
-		if slackToken != "" && slackChannel != "" {
-			slack.New(slackToken).PostMessage(slackChannel, params.Text, slack.PostMessageParameters{AsUser: true})
+		if params == nil {
+			return
+		}
+		if params.ResponseType == "in_channel" {
+			// refused or failed punches are only shown to the user
+			ctx.notifyChannel(params.Text)
 		}
-		b, _ := json.Marshal(params)
-		http.Post(responseURL, "application/json", bytes.NewBuffer(b))
+		postToResponseURL(responseURL, params)
 	}()
 
 	w.Header().Set("Content-Type", "text/plain")
 	w.Write([]byte("勤務表を更新中 :hourglass_flowing_sand:"))
 }
+
+// replyToAction replaces the message of the action with the text
+func replyToAction(w http.ResponseWriter, data *actionCallback, text string) {
+	if data.IsBlockAction {
+		go postToResponseURL(data.ResponseURL, &message{
+			Msg: slack.Msg{ReplaceOriginal: true, Text: text},
+		})
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(text))
+}
+
+func postToResponseURL(responseURL string, params *message) {
+	b, _ := json.Marshal(params)
+	slackHTTPClient.Post(responseURL, "application/json", bytes.NewBuffer(b))
+}
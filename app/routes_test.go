@@ -1,9 +1,11 @@
 package app
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
@@ -12,6 +14,7 @@ import (
 	"github.com/gorilla/mux"
 	slack "github.com/nlopes/slack"
 	"golang.org/x/oauth2"
+	null "gopkg.in/guregu/null.v3"
 	gock "gopkg.in/h2non/gock.v1"
 )
 
@@ -34,9 +37,61 @@ func TestSetupRouter(t *testing.T) {
 		"/oauth/slack/authenticate/{team}/{state}",
 		"/hooks/slash",
 		"/hooks/interactive",
+		"/hooks/events",
 	}, paths}.DeepEqual(t)
 }
 
+type unavailableStore struct {
+	*memoryStore
+}
+
+func (s unavailableStore) Get(hashKey, field string) (string, error) {
+	return "", errors.New("connection refused")
+}
+
+func (s unavailableStore) Take(hashKey, field string) (string, error) {
+	return "", errors.New("connection refused")
+}
+
+func TestUnavailableStore(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	store := unavailableStore{newMemoryStore()}
+	app.Store = store
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/oauth/salesforce/authenticate/foo", nil)
+	app.setupRouter().ServeHTTP(res, req)
+	for _, test := range []Test{
+		{503, res.Code},
+		{"connection refused\n", res.Body.String()},
+	} {
+		test.Compare(t)
+	}
+
+	// the user is not asked to login again while the store is down
+	gock.New("https://hooks.slack.test").
+		Post("/coolhook").
+		BodyString(".*" + regexp.QuoteMeta("データの読み込みに失敗しました") + ".*").
+		Reply(200)
+	res = httptest.NewRecorder()
+	req = createSlashCommandRequest(url.Values{
+		"token":        {app.SlackVerificationToken},
+		"team_id":      {"T12345678"},
+		"user_id":      {"FOO"},
+		"response_url": {"https://hooks.slack.test/coolhook"},
+	})
+	app.setupRouter().ServeHTTP(res, req)
+	time.Sleep(time.Second)
+	states, _ := store.All(app.StateStoreKey)
+	for _, test := range []Test{
+		{200, res.Code},
+		{0, len(states)},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+}
+
 func TestHandleAsset(t *testing.T) {
 	app := createMockApp()
 	res := httptest.NewRecorder()
@@ -95,7 +150,7 @@ func TestHandleFavicon(t *testing.T) {
 
 func TestHandleSalesforceAuthenticate(t *testing.T) {
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 	res := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, "https://example.com/oauth/salesforce/authenticate/", nil)
 	ctx := app.createContext(req)
@@ -113,7 +168,7 @@ func TestHandleSalesforceAuthenticate(t *testing.T) {
 
 func TestHandleSlackAuthenticate(t *testing.T) {
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 	res := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, "https://example.com/oauth/slack/authenticate/", nil)
 	ctx := app.createContext(req)
@@ -123,7 +178,7 @@ func TestHandleSlackAuthenticate(t *testing.T) {
 	app.setupRouter().ServeHTTP(res, req)
 	for _, test := range []Test{
 		{303, res.Code},
-		{"https://slack.com/oauth/authorize?client_id=ok&redirect_uri=https%3A%2F%2Fexample.com%2Foauth%2Fslack%2Fcallback&scope=chat%3Awrite%3Auser&state=" + state + "&team=T12345678", res.Header().Get("Location")},
+		{"https://slack.com/oauth/authorize?client_id=ok&redirect_uri=https%3A%2F%2Fexample.com%2Foauth%2Fslack%2Fcallback&scope=chat%3Awrite%3Auser%2Cusers%3Aread&state=" + state + "&team=T12345678", res.Header().Get("Location")},
 	} {
 		test.Compare(t)
 	}
@@ -131,7 +186,7 @@ func TestHandleSlackAuthenticate(t *testing.T) {
 
 func TestHandleSalesforceAuthenticateNotFound(t *testing.T) {
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 	res := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, "https://example.com/oauth/salesforce/authenticate/foo", nil)
 	ctx := app.createContext(req)
@@ -146,7 +201,7 @@ func TestHandleSalesforceAuthenticateNotFound(t *testing.T) {
 
 func TestHandleSlackAuthenticateNotFound(t *testing.T) {
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 	res := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, "https://example.com/oauth/slack/authenticate/T12345678/foo", nil)
 	ctx := app.createContext(req)
@@ -172,20 +227,21 @@ func TestHandleSalesforceOAuthCallback(t *testing.T) {
 			Expiry:       expiry,
 		})
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 	res := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
 	ctx := app.createContext(req)
 	ctx.UserID = "FOO"
 	state, _ := ctx.storeState(State{TeamID: "T123456", UserID: "FOO"})
-	token := ctx.getSalesforceAccessTokenForUser()
+	token, _ := ctx.getSalesforceAccessTokenForUser()
 	Test{true, token == nil}.Compare(t)
 	req, _ = http.NewRequest(http.MethodGet, "https://example.com/oauth/salesforce/callback?state="+state+"&code=fjkfjk", nil)
 	app.setupRouter().ServeHTTP(res, req)
-	token = ctx.getSalesforceAccessTokenForUser()
+	token, _ = ctx.getSalesforceAccessTokenForUser()
 	for _, test := range []Test{
 		{302, res.Code},
-		{"https://example.com/oauth/slack/authenticate/T123456/" + state, res.Header().Get("Location")},
+		{0, strings.Index(res.Header().Get("Location"), "https://example.com/oauth/slack/authenticate/T123456/")},
+		{false, strings.HasSuffix(res.Header().Get("Location"), "/"+state)},
 		{false, token == nil},
 		{"bar", token.RefreshToken},
 		{"foo", token.AccessToken},
@@ -193,6 +249,23 @@ func TestHandleSalesforceOAuthCallback(t *testing.T) {
 	} {
 		test.Compare(t)
 	}
+	res = httptest.NewRecorder()
+	app.setupRouter().ServeHTTP(res, req)
+	Test{404, res.Code}.Compare(t)
+}
+
+func TestHandleSalesforceAuthenticateExpired(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	ctx.StateTTL = -time.Minute
+	state, _ := ctx.storeState(State{TeamID: "T123456"})
+	req, _ = http.NewRequest(http.MethodGet, "https://example.com/oauth/salesforce/authenticate/"+state, nil)
+	app.setupRouter().ServeHTTP(res, req)
+	Test{404, res.Code}.Compare(t)
 }
 
 func TestHandleSalesforceOAuthCallbackError(t *testing.T) {
@@ -203,7 +276,7 @@ func TestHandleSalesforceOAuthCallbackError(t *testing.T) {
 		BodyString("NG")
 
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 	res := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
 	ctx := app.createContext(req)
@@ -232,21 +305,25 @@ func TestHandleSlackOAuthCallback(t *testing.T) {
 	gock.InterceptClient(client)
 	slack.SetHTTPClient(client)
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 	res := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
 	ctx := app.createContext(req)
 	ctx.UserID = "FOO"
 	state, _ := ctx.storeState(State{TeamID: "T123456"})
-	token := ctx.getSlackAccessTokenForUser()
+	token, _ := ctx.getSlackAccessTokenForUser()
 	Test{"", token}.Compare(t)
+	// the state of the login from a DM has no response_url
+	setupDirectMessageGock(url.QueryEscape("認証が完了しました"), "yo")
 	req, _ = http.NewRequest(http.MethodGet, "https://example.com/oauth/slack/callback?state="+state+"&code=fjkfjk", nil)
 	app.setupRouter().ServeHTTP(res, req)
-	token = ctx.getSlackAccessTokenForUser()
+	time.Sleep(time.Second)
+	token, _ = ctx.getSlackAccessTokenForUser()
 	for _, test := range []Test{
 		{302, res.Code},
 		{"yo", token},
 		{"/success", res.Header().Get("Location")},
+		{true, gock.IsDone()},
 	} {
 		test.Compare(t)
 	}
@@ -268,17 +345,17 @@ func TestHandleSlackOAuthCallbackError(t *testing.T) {
 	gock.InterceptClient(client)
 	slack.SetHTTPClient(client)
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 	res := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
 	ctx := app.createContext(req)
 	ctx.UserID = "FOO"
 	state, _ := ctx.storeState(State{TeamID: "T123456"})
-	token := ctx.getSlackAccessTokenForUser()
+	token, _ := ctx.getSlackAccessTokenForUser()
 	Test{"", token}.Compare(t)
 	req, _ = http.NewRequest(http.MethodGet, "https://example.com/oauth/slack/callback?state="+state+"&code=fjkfjk", nil)
 	app.setupRouter().ServeHTTP(res, req)
-	token = ctx.getSlackAccessTokenForUser()
+	token, _ = ctx.getSlackAccessTokenForUser()
 	for _, test := range []Test{
 		{500, res.Code},
 		{"omg\n", res.Body.String()},
@@ -297,7 +374,7 @@ func createSlashCommandRequest(data url.Values) *http.Request {
 func TestHandleSlashCommand(t *testing.T) {
 	defer gock.Off()
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 	res := httptest.NewRecorder()
 	req := createSlashCommandRequest(url.Values{
 		"token": {"hoge"},
@@ -324,7 +401,7 @@ func TestHandleSlashCommand(t *testing.T) {
 func TestHandleActionCallback(t *testing.T) {
 	defer gock.Off()
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 
 	res := httptest.NewRecorder()
 	req := createActionCallbackRequest(callbackIDAttendanceButton, actionTypeAttend, "foo")
@@ -354,20 +431,14 @@ func TestHandleActionCallback(t *testing.T) {
 		Reply(200).
 		JSON([]map[string]interface{}{{"success": true}})
 
-	gock.New("https://teamspirit-1234.cloudforce.test").
-		Get("/services/apexrest/Dakoku").
-		Reply(200).
-		JSON(map[string]interface{}{
-			"timeTable": []map[string]interface{}{{"from": 1, "to": 2, "type": 1}},
-			"isHoliday": false,
-		})
+	setupTimeTableGocks([]timeTableItem{}, &[]bool{false}[0])
 
 	gock.New("https://teamspirit-1234.cloudforce.test").
 		Put("/services/apexrest/Dakoku").
 		Reply(200).
 		BodyString("OK")
 
-	app.CleanRedis()
+	app.CleanStore()
 	res = httptest.NewRecorder()
 	req = createActionCallbackRequest(callbackIDAttendanceButton, actionTypeAttend, app.SlackVerificationToken)
 	ctx := app.createContext(req)
@@ -388,7 +459,7 @@ func TestHandleActionCallback(t *testing.T) {
 		test.Compare(t)
 	}
 
-	app.CleanRedis()
+	app.CleanStore()
 	res = httptest.NewRecorder()
 	req = createActionCallbackRequest(callbackIDChannelSelect, actionTypeSelectChannel, app.SlackVerificationToken)
 	ctx = app.createContext(req)
@@ -409,7 +480,7 @@ func TestHandleActionCallback(t *testing.T) {
 		test.Compare(t)
 	}
 
-	app.CleanRedis()
+	app.CleanStore()
 	res = httptest.NewRecorder()
 	req = createActionCallbackRequest(callbackIDChannelSelect, actionTypeUnselectChannel, app.SlackVerificationToken)
 	ctx = app.createContext(req)
@@ -429,4 +500,96 @@ func TestHandleActionCallback(t *testing.T) {
 	} {
 		test.Compare(t)
 	}
+
+	res = httptest.NewRecorder()
+	req = createBlockActionCallbackRequest(callbackIDChannelSelect, actionTypeSelectChannel, app.SlackVerificationToken)
+	gock.New("https://hooks.slack.test").
+		Post("/coolhook").
+		Reply(200)
+	app.setupRouter().ServeHTTP(res, req)
+	time.Sleep(time.Second)
+	ctx = app.createContext(req)
+	ctx.UserID = "FOO"
+	channel, _ := ctx.getSlackNotifyChannelForUser()
+	for _, test := range []Test{
+		{200, res.Code},
+		{"<#C1234567> に通知します :mega:", res.Body.String()},
+		{"C1234567", channel},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+
+	// URL buttons are acknowledged without touching TeamSpirit
+	setupTimeTableGocks([]timeTableItem{}, &[]bool{false}[0])
+	for _, action := range []string{actionTypeAuthenticate, actionTypeSlackAuthenticate} {
+		res = httptest.NewRecorder()
+		req = createBlockActionCallbackRequest(callbackIDAttendanceButton, action, app.SlackVerificationToken)
+		app.setupRouter().ServeHTTP(res, req)
+		time.Sleep(100 * time.Millisecond)
+		for _, test := range []Test{
+			{200, res.Code},
+			{"", res.Body.String()},
+			{true, gock.IsPending()},
+		} {
+			test.Compare(t)
+		}
+	}
+
+	// failed actions without response_url are only logged
+	data := url.Values{}
+	data.Set("payload", `{"type":"block_actions","token":"`+app.SlackVerificationToken+`","user":{"id":"FOO"},"actions":[{"action_id":"unrest-at","value":"foo"}]}`)
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "https://example.com/hooks/interactive", strings.NewReader(data.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	app.setupRouter().ServeHTTP(res, req)
+	time.Sleep(100 * time.Millisecond)
+	for _, test := range []Test{
+		{200, res.Code},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestHandleActionCallbackNotifyChannel(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	for _, test := range []struct {
+		items    []timeTableItem
+		notified bool
+	}{
+		{[]timeTableItem{}, true},
+		{[]timeTableItem{{From: null.IntFrom(540), Type: 1}}, false},
+	} {
+		app.CleanStore()
+		ctx := createLoggedInTestContext(app, getMockTime())
+		ctx.setVariableInHash(ctx.NotifyChannelStoreKey, "C1234567")
+		setupTimeTableGocks(test.items, &[]bool{false}[0])
+		if test.notified {
+			gock.New("https://teamspirit-1234.cloudforce.test").
+				Put("/services/apexrest/Dakoku").
+				Reply(200).
+				BodyString(`"OK"`)
+		}
+		gock.New("https://hooks.slack.test").
+			Post("/coolhook").
+			Reply(200)
+		gock.New("https://slack.com").
+			Post("/api/chat.postMessage").
+			BodyString("channel=C1234567").
+			Reply(200).
+			JSON(map[string]interface{}{"ok": true})
+		res := httptest.NewRecorder()
+		req := createBlockActionCallbackRequest(callbackIDAttendanceButton, actionTypeAttend, app.SlackVerificationToken)
+		app.setupRouter().ServeHTTP(res, req)
+		time.Sleep(time.Second)
+		for _, test := range []Test{
+			{200, res.Code},
+			{test.notified, gock.IsDone()},
+		} {
+			test.Compare(t)
+		}
+		gock.Off()
+	}
 }
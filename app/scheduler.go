@@ -0,0 +1,91 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// schedulerConcurrency is how many users a scheduled job handles at the same time
+const schedulerConcurrency = 8
+
+// schedulerInterval is how often the scheduled jobs run
+var schedulerInterval = time.Minute
+
+// scheduledJobs run in the background of the web server
+var scheduledJobs = []func(app *App, now time.Time){
+	(*App).sendReminders,
+	(*App).sendLeaveNudges,
+	(*App).watchOpenRests,
+	(*App).watchPresences,
+	(*App).purgeExpiredEntries,
+}
+
+// startScheduler runs each scheduled job every schedulerInterval until stop is closed.
+// Jobs have their own tickers so that a slow job does not delay the others, and a job never overlaps its previous run
+func (app *App) startScheduler(stop <-chan struct{}) {
+	for _, job := range scheduledJobs {
+		ticker := time.NewTicker(schedulerInterval)
+		go func(job func(app *App, now time.Time)) {
+			defer ticker.Stop()
+			for {
+				select {
+				case now := <-ticker.C:
+					job(app, now)
+				case <-stop:
+					return
+				}
+			}
+		}(job)
+	}
+}
+
+// RunScheduler runs the scheduled jobs without the web server, for deployments where the web server runs with DISABLE_SCHEDULER
+func RunScheduler() error {
+	app, err := new()
+	if err != nil {
+		return err
+	}
+	app.startScheduler(make(chan struct{}))
+	select {}
+}
+
+// forEachUser calls fn for the users, at most schedulerConcurrency at the same time, and waits for all of them
+func forEachUser(userIDs map[string]string, fn func(userID string)) {
+	sem := make(chan struct{}, schedulerConcurrency)
+	var wg sync.WaitGroup
+	for userID := range userIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(userID string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(userID)
+		}(userID)
+	}
+	wg.Wait()
+}
+
+// purgeExpiredEntries deletes the expired states and event IDs, which are not scanned in the requests
+func (app *App) purgeExpiredEntries(now time.Time) {
+	ctx := app.createUserContext("", "", now)
+	if err := ctx.purgeExpiredStates(); err != nil {
+		fmt.Printf("Purge States Error: %+v\n", err.Error())
+	}
+	if err := ctx.purgeExpiredEvents(); err != nil {
+		fmt.Printf("Purge Events Error: %+v\n", err.Error())
+	}
+}
+
+// createUserContext returns a context for the user outside of a Slack request
+func (app *App) createUserContext(teamID, userID string, now time.Time) *Context {
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	ctx := app.createContext(req)
+	ctx.TeamID = teamID
+	ctx.UserID = userID
+	ctx.now = func() time.Time { return now }
+	return ctx
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+// setupDirectMessageGock mocks the DM to the user FOO sent with the token, whose body matches the pattern before the channel
+func setupDirectMessageGock(pattern, token string) {
+	gock.New("https://slack.com").
+		Post("/api/chat.postMessage").
+		BodyString(pattern + ".*channel=FOO.*token=" + token).
+		Reply(200).
+		JSON(map[string]interface{}{"ok": true})
+}
+
+func TestCreateUserContext(t *testing.T) {
+	app := createMockApp()
+	ctx := app.createUserContext("T12345678", "FOO", getMockTime())
+	for _, test := range []Test{
+		{"T12345678", ctx.TeamID},
+		{"FOO", ctx.UserID},
+		{true, getMockTime().Equal(ctx.now())},
+		{app.ReminderStoreKey, ctx.ReminderStoreKey},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestStartScheduler(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	originalJobs, originalInterval := scheduledJobs, schedulerInterval
+	defer func() { scheduledJobs, schedulerInterval = originalJobs, originalInterval }()
+	schedulerInterval = 10 * time.Millisecond
+	release := make(chan struct{})
+	times := make(chan time.Time, 1)
+	scheduledJobs = []func(app *App, now time.Time){
+		func(app *App, now time.Time) { <-release },
+		func(app *App, now time.Time) {
+			select {
+			case times <- now:
+			default:
+			}
+		},
+	}
+	stop := make(chan struct{})
+	app.startScheduler(stop)
+	defer close(stop)
+	defer close(release)
+
+	// the second job keeps running while the first one is blocked
+	for i := 0; i < 3; i++ {
+		select {
+		case now := <-times:
+			Test{false, now.IsZero()}.Compare(t)
+		case <-time.After(time.Second):
+			t.Fatal("Scheduled job did not run")
+		}
+	}
+}
+
+func TestForEachUser(t *testing.T) {
+	userIDs := map[string]string{}
+	for i := 0; i < schedulerConcurrency*3; i++ {
+		userIDs["U"+strconv.Itoa(i)] = ""
+	}
+	var mutex sync.Mutex
+	called := map[string]bool{}
+	running, maxRunning := 0, 0
+	forEachUser(userIDs, func(userID string) {
+		mutex.Lock()
+		called[userID] = true
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+	})
+	for _, test := range []Test{
+		{len(userIDs), len(called)},
+		{true, maxRunning <= schedulerConcurrency},
+		{true, maxRunning > 1},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestPurgeExpiredEntries(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	ctx := app.createUserContext("", "FOO", getMockTime())
+	ctx.receiveEvent("Ev1")
+	ctx.Store.Set(ctx.StateStoreKey, "expired", `{"e":`+strconv.FormatInt(getMockTime().Unix(), 10)+`}`)
+	ctx.Store.Set(ctx.StateStoreKey, "valid", `{"e":`+strconv.FormatInt(getMockTime().Add(time.Minute).Unix(), 10)+`}`)
+
+	app.purgeExpiredEntries(getMockTime().Add(time.Second))
+	events, _ := app.Store.All(app.EventStoreKey)
+	states, _ := app.Store.All(app.StateStoreKey)
+	_, ok := states["valid"]
+	for _, test := range []Test{
+		{1, len(events)},
+		{1, len(states)},
+		{true, ok},
+	} {
+		test.Compare(t)
+	}
+
+	app.purgeExpiredEntries(getMockTime().Add(eventTTL))
+	events, _ = app.Store.All(app.EventStoreKey)
+	Test{0, len(events)}.Compare(t)
+}
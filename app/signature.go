@@ -0,0 +1,65 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const slackSignatureVersion = "v0"
+
+// slackRequestTolerance is the replay window for Slack request timestamps
+const slackRequestTolerance = 5 * time.Minute
+
+func computeSlackSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(slackSignatureVersion + ":" + timestamp + ":"))
+	mac.Write(body)
+	return slackSignatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifySlackSignature(secret string, header http.Header, body []byte, now time.Time) error {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	signature := header.Get("X-Slack-Signature")
+	if timestamp == "" || signature == "" {
+		return errors.New("Missing signature")
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("Invalid timestamp")
+	}
+	diff := now.Sub(time.Unix(sec, 0))
+	if diff > slackRequestTolerance || diff < -slackRequestTolerance {
+		return errors.New("Request is too old")
+	}
+	if !hmac.Equal([]byte(signature), []byte(computeSlackSignature(secret, timestamp, body))) {
+		return errors.New("Invalid signature")
+	}
+	return nil
+}
+
+func (app *App) verifySlackRequest(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.SlackSigningSecret == "" {
+			handler(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+		if err := verifySlackSignature(app.SlackSigningSecret, r.Header, body, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
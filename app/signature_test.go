@@ -0,0 +1,92 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signSlackRequest(req *http.Request, secret string, body string, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", computeSlackSignature(secret, timestamp, []byte(body)))
+}
+
+func TestComputeSlackSignature(t *testing.T) {
+	// https://api.slack.com/authentication/verifying-requests-from-slack
+	body := "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+	Test{
+		"v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503",
+		computeSlackSignature("8f742231b10e8888abcd99yyyzzz85a5", "1531420618", []byte(body)),
+	}.Compare(t)
+}
+
+func TestVerifySlackSignature(t *testing.T) {
+	now := time.Now()
+	body := []byte("foo=bar")
+	for _, test := range []struct {
+		timestamp string
+		signature string
+		expected  string
+	}{
+		{"", "", "Missing signature"},
+		{"foo", "v0=foo", "Invalid timestamp"},
+		{strconv.FormatInt(now.Add(-6*time.Minute).Unix(), 10), "v0=foo", "Request is too old"},
+		{strconv.FormatInt(now.Add(6*time.Minute).Unix(), 10), "v0=foo", "Request is too old"},
+		{strconv.FormatInt(now.Unix(), 10), "v0=foo", "Invalid signature"},
+	} {
+		header := http.Header{}
+		header.Set("X-Slack-Request-Timestamp", test.timestamp)
+		header.Set("X-Slack-Signature", test.signature)
+		err := verifySlackSignature("secret", header, body, now)
+		Test{test.expected, err.Error()}.Compare(t)
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	header := http.Header{}
+	header.Set("X-Slack-Request-Timestamp", timestamp)
+	header.Set("X-Slack-Signature", computeSlackSignature("secret", timestamp, body))
+	Test{nil, verifySlackSignature("secret", header, body, now)}.Compare(t)
+}
+
+func TestVerifySlackRequest(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	app.SlackSigningSecret = "secret"
+	data := url.Values{"token": {"invalid"}}
+
+	res := httptest.NewRecorder()
+	req := createSlashCommandRequest(data)
+	app.setupRouter().ServeHTTP(res, req)
+	for _, test := range []Test{
+		{401, res.Code},
+		{"Missing signature\n", res.Body.String()},
+	} {
+		test.Compare(t)
+	}
+
+	res = httptest.NewRecorder()
+	req = createSlashCommandRequest(data)
+	signSlackRequest(req, "wrong", data.Encode(), time.Now())
+	app.setupRouter().ServeHTTP(res, req)
+	for _, test := range []Test{
+		{401, res.Code},
+		{"Invalid signature\n", res.Body.String()},
+	} {
+		test.Compare(t)
+	}
+
+	res = httptest.NewRecorder()
+	req = createSlashCommandRequest(data)
+	signSlackRequest(req, "secret", data.Encode(), time.Now())
+	app.setupRouter().ServeHTTP(res, req)
+	time.Sleep(time.Second)
+	for _, test := range []Test{
+		{200, res.Code},
+		{"", res.Body.String()},
+	} {
+		test.Compare(t)
+	}
+}
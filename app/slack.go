@@ -1,254 +1,369 @@
 package app
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/nlopes/slack"
+	null "gopkg.in/guregu/null.v3"
 )
 
 const (
-	actionTypeAttend           = "attend"
-	actionTypeRest             = "rest"
-	actionTypeUnrest           = "unrest"
-	actionTypeLeave            = "leave"
-	actionTypeSelectChannel    = "select-channel"
-	actionTypeUnselectChannel  = "unselect-channel"
-	callbackIDChannelSelect    = "slack_channel_select_button"
-	callbackIDAttendanceButton = "attendance_button"
+	actionTypeAttend            = "attend"
+	actionTypeRest              = "rest"
+	actionTypeUnrest            = "unrest"
+	actionTypeAway              = "away"
+	actionTypeLeave             = "leave"
+	actionTypeSelectChannel     = "select-channel"
+	actionTypeUnselectChannel   = "unselect-channel"
+	actionTypeAuthenticate      = "authenticate"
+	actionTypeSlackAuthenticate = "slack-authenticate"
+	actionTypeSnoozeLeave       = "snooze-leave"
+	actionTypeUnrestAt          = "unrest-at"
+	actionTypeRestAt            = "rest-at"
+	callbackIDChannelSelect     = "slack_channel_select_button"
+	callbackIDAttendanceButton  = "attendance_button"
+	callbackIDLeaveNudge        = "leave_nudge_button"
+	callbackIDRestWatchdog      = "rest_watchdog_button"
+	callbackIDPresence          = "presence_button"
 )
 
-func (ctx *Context) getActionCallback(data *slack.AttachmentActionCallback) (*slack.Msg, string, error) {
-	ctx.UserID = data.User.ID
-	client := ctx.createTimeTableClient()
-	timeTable, err := client.GetTimeTable()
+// slackTimeout is the deadline of each request to Slack, which is also called from the scheduled jobs
+const slackTimeout = 10 * time.Second
+
+// slackHTTPClient is the client of the Slack API and the response_url
+var slackHTTPClient = &http.Client{Timeout: slackTimeout}
+
+func init() {
+	slack.SetHTTPClient(slackHTTPClient)
+}
+
+// timedActionTypes maps the actions which carry the punch time in their value to the punch action
+var timedActionTypes = map[string]string{
+	actionTypeUnrestAt: actionTypeUnrest,
+	actionTypeRestAt:   actionTypeRest,
+}
+
+// isPunchAction returns true if the action updates the time table, unlike URL buttons which also send block_actions
+func isPunchAction(action string) bool {
+	if _, ok := timedActionTypes[action]; ok {
+		return true
+	}
+	_, ok := punchMessages[action]
+	return ok
+}
+
+// withSummary prepends the timeline summary to the blocks if any
+func withSummary(summary string, blocks ...block) []block {
+	if summary == "" {
+		return blocks
+	}
+	return append([]block{sectionBlock(summary)}, blocks...)
+}
+
+func (ctx *Context) getActionCallback(data *actionCallback) (*message, string, error) {
+	ctx.UserID = data.UserID
+	ctx.TeamID = data.TeamID
+	if !isPunchAction(data.ActionName) {
+		return nil, data.ResponseURL, fmt.Errorf("Unknown action: %s", data.ActionName)
+	}
+	reqCtx, cancel := ctx.newRequestContext()
+	defer cancel()
+	client, err := ctx.createTimeTableClient(reqCtx)
+	var timeTable *timeTable
+	if err == nil {
+		timeTable, err = client.GetCurrentTimeTable(reqCtx, ctx.localNow())
+	}
 	if err != nil {
 		state := State{
-			TeamID:      data.Team.ID,
+			TeamID:      data.TeamID,
 			UserID:      ctx.UserID,
 			ResponseURL: data.ResponseURL,
 		}
-		err, msg := ctx.getLoginSlackMessage(state)
-		return err, data.ResponseURL, msg
-	}
-
-	text := ""
-	now := time.Now()
-	attendance := -1
-	switch data.Actions[0].Name {
-	case actionTypeLeave:
-		{
-			attendance = 0
-			text = "退勤しました :house:"
-		}
-	case actionTypeRest:
-		{
-			timeTable.Rest(now)
-			text = "休憩を開始しました :coffee:"
-		}
-	case actionTypeUnrest:
-		{
-			timeTable.Unrest(now)
-			text = "休憩を終了しました :computer:"
+		msg, err := ctx.getTimeTableErrorSlackMessage(state, err)
+		return msg, data.ResponseURL, err
+	}
+
+	action := data.ActionName
+	var pt *punchTime
+	if timedAction, ok := timedActionTypes[action]; ok {
+		// the time chosen in the watchdog or presence message
+		minutes, err := strconv.ParseInt(data.SelectedValue, 10, 64)
+		if err != nil {
+			return nil, data.ResponseURL, err
 		}
-	case actionTypeAttend:
-		{
-			attendance = 1
-			text = "出勤しました :office:"
+		action = timedAction
+		pt = &punchTime{From: null.IntFrom(minutes)}
+	}
+	// buttons in DMs may be clicked long after they were sent
+	if text := ctx.getPunchError(timeTable, action, pt); text != "" {
+		return getErrorSlackMessage(text), data.ResponseURL, nil
+	}
+	if pt != nil {
+		if text := timeTable.validatePunchTime(action, pt, timeTable.convertTime(ctx.localNow()).Int64); text != "" {
+			return getErrorSlackMessage(text), data.ResponseURL, nil
 		}
 	}
 
-	params := &slack.Msg{
-		ResponseType:    "in_channel",
-		ReplaceOriginal: true,
-		Text:            text,
+	params := &message{
+		Msg: slack.Msg{
+			ResponseType:    "in_channel",
+			ReplaceOriginal: true,
+			Text:            getPunchText(timeTable, action, pt),
+		},
 	}
 
 	var ok bool
-	if attendance != -1 {
-		ok, err = client.SetAttendance(attendance == 1)
+	if pt != nil {
+		ok, err = ctx.punchAt(reqCtx, client, timeTable, action, pt)
 	} else {
-		ok, err = client.UpdateTimeTable(timeTable)
+		ok, err = ctx.punch(reqCtx, client, timeTable, action, ctx.localNow())
 	}
 	if !ok || err != nil {
 		params.ResponseType = "ephemeral"
 		params.ReplaceOriginal = false
-		params.Text = "勤務表の更新に失敗しました :warning:"
+		params.Text = getPunchFailedText(err)
 	}
 
 	return params, data.ResponseURL, nil
 }
 
-func (ctx *Context) getLoginSlackMessage(state State) (*slack.Msg, error) {
+func (ctx *Context) getLoginSlackMessage(state State) (*message, error) {
 	stateKey, err := ctx.storeState(state)
 	if err != nil {
 		return nil, err
 	}
-	return &slack.Msg{
-		Attachments: []slack.Attachment{
-			slack.Attachment{
-				Text:       "TeamSpirit で認証を行って、再度 `/ts` コマンドを実行してください :bow:",
-				CallbackID: callbackIDAttendanceButton,
-				Actions: []slack.AttachmentAction{
-					slack.AttachmentAction{
-						Name:  "authenticate",
-						Value: "authenticate",
-						Text:  "認証する",
-						Style: "primary",
-						Type:  "button",
-						URL:   ctx.getSalesforceAuthenticateURL(stateKey),
-					},
-				},
-			},
+	button := buttonElement(actionTypeAuthenticate, "認証する", "primary")
+	button.URL = ctx.getSalesforceAuthenticateURL(stateKey)
+	return &message{
+		Blocks: []block{
+			sectionBlock("TeamSpirit で認証を行って、再度 `/ts` コマンドを実行してください :bow:"),
+			actionsBlock(callbackIDAttendanceButton, button),
 		},
 	}, nil
 }
 
-func (ctx *Context) getAuthenticateSlackMessage(state State) (*slack.Msg, error) {
+// getTimeTableErrorSlackMessage asks the user to login again only if the error is caused by authentication
+func (ctx *Context) getTimeTableErrorSlackMessage(state State, err error) (*message, error) {
+	if !isUnauthorizedError(err) {
+		return getErrorSlackMessage(getTimeTableErrorText(err)), nil
+	}
+	msg, loginErr := ctx.getLoginSlackMessage(state)
+	if loginErr != nil {
+		return nil, loginErr
+	}
+	if err == errRefreshTokenRevoked {
+		msg.Blocks = append([]block{contextBlock("TeamSpirit の認証が取り消されたか、有効期限が切れました")}, msg.Blocks...)
+	}
+	return msg, nil
+}
+
+func getTimeTableErrorText(err error) string {
+	if _, ok := err.(*storeError); ok {
+		return "データの読み込みに失敗しました。しばらくしてから再度お試しください :warning:"
+	}
+	if err == errTimeout {
+		return "TeamSpirit から応答がありませんでした。しばらくしてから再度お試しください :hourglass:"
+	}
+	if err == errConflict {
+		return "勤務表が TeamSpirit で更新されたため、打刻できませんでした。再度お試しください :warning:"
+	}
+	if apiErr, ok := err.(*timeTableError); ok {
+		return "TeamSpirit でエラーが発生しました: " + apiErr.Message + " (" + apiErr.Code + ") :warning:"
+	}
+	return "TeamSpirit との通信に失敗しました :warning:"
+}
+
+func getPunchFailedText(err error) string {
+	if err == nil {
+		return punchFailedMessage
+	}
+	if isUnauthorizedError(err) {
+		return "TeamSpirit の認証が切れました。`/ts login` で再度認証してください :bow:"
+	}
+	return getTimeTableErrorText(err)
+}
+
+// getAuthenticateSlackMessage asks the user to authenticate with Slack and run the subcommand again
+func (ctx *Context) getAuthenticateSlackMessage(state State, name string) (*message, error) {
 	stateKey, err := ctx.storeState(state)
 	if err != nil {
 		return nil, err
 	}
-	return &slack.Msg{
-		Attachments: []slack.Attachment{
-			slack.Attachment{
-				Text:       "Slack で認証を行って、再度 `/ts channel` コマンドを実行してください :bow:",
-				CallbackID: "slack_authentication_button",
-				Actions: []slack.AttachmentAction{
-					slack.AttachmentAction{
-						Name:  "slack-authenticate",
-						Value: "slack-authenticate",
-						Text:  "認証する",
-						Style: "primary",
-						Type:  "button",
-						URL:   ctx.getSlackAuthenticateURL(state.TeamID, stateKey),
-					},
-				},
-			},
+	button := buttonElement(actionTypeSlackAuthenticate, "認証する", "primary")
+	button.URL = ctx.getSlackAuthenticateURL(state.TeamID, stateKey)
+	return &message{
+		Blocks: []block{
+			sectionBlock("Slack で認証を行って、再度 `/ts " + name + "` コマンドを実行してください :bow:"),
+			actionsBlock("slack_authentication_button", button),
 		},
 	}, nil
 }
 
-func (ctx *Context) getChannelSelectSlackMessage() (*slack.Msg, error) {
-	return &slack.Msg{
-		Attachments: []slack.Attachment{
-			slack.Attachment{
-				Text:       "打刻時に通知するチャネルを選択して下さい",
-				CallbackID: callbackIDChannelSelect,
-				Actions: []slack.AttachmentAction{
-					slack.AttachmentAction{
-						Name:       actionTypeSelectChannel,
-						Value:      actionTypeSelectChannel,
-						Text:       "チャネルを選択",
-						Type:       "select",
-						DataSource: "channels",
-					},
-					slack.AttachmentAction{
-						Name:  actionTypeUnrest,
-						Value: actionTypeUnrest,
-						Text:  "通知を止める",
-						Style: "danger",
-						Type:  "button",
-					},
-				},
-			},
+func (ctx *Context) getLogoutSlackMessage(input *commandInput) (*message, error) {
+	loggedIn, err := ctx.logout()
+	if err != nil {
+		return getErrorSlackMessage("連携の解除に失敗しました :warning:"), err
+	}
+	text := "TeamSpirit と Slack の連携を解除しました :wave:"
+	if !loggedIn {
+		text = "連携しているアカウントはありません"
+	}
+	return getEphemeralSlackMessage(text), nil
+}
+
+func (ctx *Context) getChannelSelectSlackMessage() (*message, error) {
+	return &message{
+		Blocks: []block{
+			sectionBlock("打刻時に通知するチャネルを選択して下さい"),
+			actionsBlock(callbackIDChannelSelect,
+				conversationsSelectElement(actionTypeSelectChannel, "チャネルを選択"),
+				buttonElement(actionTypeUnselectChannel, "通知を止める", "danger"),
+			),
 		},
 	}, nil
 }
 
-func (ctx *Context) getSlackMessage(command slack.SlashCommand) (*slack.Msg, error) {
-	text := command.Text
-	state := State{
-		TeamID:      command.TeamID,
-		UserID:      command.UserID,
-		ResponseURL: command.ResponseURL,
-	}
-	client := ctx.createTimeTableClient()
-	if client.HTTPClient == nil || text == "login" {
-		return ctx.getLoginSlackMessage(state)
-	}
-	timeTable, err := client.GetTimeTable()
-	if err != nil {
-		return ctx.getLoginSlackMessage(state)
-	}
-	if text == "channel" {
-		if ctx.getSlackAccessTokenForUser() == "" {
-			return ctx.getAuthenticateSlackMessage(state)
-		}
-		return ctx.getChannelSelectSlackMessage()
-	}
+func (ctx *Context) getSlackMessage(command slack.SlashCommand) (*message, error) {
+	name, args := parseCommandText(command.Text)
+	return ctx.runSubcommand(&commandInput{
+		Name: name,
+		Args: args,
+		State: State{
+			TeamID:      command.TeamID,
+			UserID:      command.UserID,
+			ResponseURL: command.ResponseURL,
+		},
+	})
+}
+
+func (ctx *Context) getStatusSlackMessage(input *commandInput) (*message, error) {
+	timeTable := input.TimeTable
+	summary := timeTable.GetSummary(timeTable.convertTime(ctx.localNow()).Int64)
 	if timeTable.IsLeaving() {
-		return &slack.Msg{
-			Text: "既に退勤済です。打刻修正は <https://" + ctx.TeamSpiritHost + "|TeamSpirit> で行なってください。",
+		text := "既に退勤済です。打刻修正は <https://" + ctx.TeamSpiritHost + "|TeamSpirit> で行なってください。"
+		return &message{
+			Msg: slack.Msg{
+				Text: text,
+			},
+			Blocks: append([]block{sectionBlock(text)}, withSummary(summary)...),
 		}, nil
 	}
 	if timeTable.IsHoliday != nil && *timeTable.IsHoliday == true {
-		return &slack.Msg{
-			Text: "本日は休日です :sunny:",
+		return &message{
+			Msg: slack.Msg{
+				Text: "本日は休日です :sunny:",
+			},
 		}, nil
 	}
-	if timeTable.IsResting() {
-		return &slack.Msg{
-			Attachments: []slack.Attachment{
-				slack.Attachment{
-					CallbackID: callbackIDAttendanceButton,
-					Actions: []slack.AttachmentAction{
-						slack.AttachmentAction{
-							Name:  actionTypeUnrest,
-							Value: actionTypeUnrest,
-							Text:  "休憩を終了する",
-							Style: "default",
-							Type:  "button",
-						},
-					},
-				},
-			},
+	if item := timeTable.getOpenRest(); item != nil {
+		text := "休憩を終了する"
+		if item.IsAway() {
+			text = "私用外出から戻る"
+		}
+		return &message{
+			Blocks: withSummary(summary,
+				actionsBlock(callbackIDAttendanceButton,
+					buttonElement(actionTypeUnrest, text, ""),
+				),
+			),
 		}, nil
 	}
 	if timeTable.IsAttending() {
-		return &slack.Msg{
-			Attachments: []slack.Attachment{
-				slack.Attachment{
-					CallbackID: callbackIDAttendanceButton,
-					Actions: []slack.AttachmentAction{
-						slack.AttachmentAction{
-							Name:  actionTypeRest,
-							Value: actionTypeRest,
-							Text:  "休憩を開始する",
-							Style: "default",
-							Type:  "button",
-						},
-						slack.AttachmentAction{
-							Name:  actionTypeLeave,
-							Value: actionTypeLeave,
-							Text:  "退勤する",
-							Style: "danger",
-							Type:  "button",
-							Confirm: &slack.ConfirmationField{
-								Text:        "退勤しますか？",
-								OkText:      "はい",
-								DismissText: "いいえ",
-							},
-						},
-					},
-				},
-			},
+		leave := buttonElement(actionTypeLeave, "退勤する", "danger")
+		leave.Confirm = confirmDialog("退勤", "退勤しますか？", "はい", "いいえ")
+		return &message{
+			Blocks: withSummary(summary,
+				actionsBlock(callbackIDAttendanceButton,
+					buttonElement(actionTypeRest, "休憩を開始する", ""),
+					buttonElement(actionTypeAway, "私用外出する", ""),
+					leave,
+				),
+			),
 		}, nil
 	}
-	return &slack.Msg{
-		Attachments: []slack.Attachment{
-			slack.Attachment{
-				CallbackID: callbackIDAttendanceButton,
-				Actions: []slack.AttachmentAction{
-					slack.AttachmentAction{
-						Name:  actionTypeAttend,
-						Value: actionTypeAttend,
-						Text:  "出勤する",
-						Style: "primary",
-						Type:  "button",
-					},
-				},
-			},
+	return &message{
+		Blocks: []block{
+			actionsBlock(callbackIDAttendanceButton,
+				buttonElement(actionTypeAttend, "出勤する", "primary"),
+			),
 		},
 	}, nil
+}
 
+// postSlackMessage posts the message with blocks, which chat.postMessage of the vendored client does not support
+func postSlackMessage(token, channel string, msg *message) error {
+	values := url.Values{
+		"token":   {token},
+		"channel": {channel},
+		"text":    {msg.Text},
+	}
+	if len(msg.Blocks) > 0 {
+		b, err := json.Marshal(msg.Blocks)
+		if err != nil {
+			return err
+		}
+		values.Set("blocks", string(b))
+	}
+	return callSlackAPI("chat.postMessage", values)
+}
+
+// publishSlackHomeView publishes the blocks to the App Home tab of the user, which the vendored client does not support
+func publishSlackHomeView(token, userID string, blocks []block) error {
+	b, err := json.Marshal(map[string]interface{}{
+		"type":   "home",
+		"blocks": blocks,
+	})
+	if err != nil {
+		return err
+	}
+	return callSlackAPI("views.publish", url.Values{
+		"token":   {token},
+		"user_id": {userID},
+		"view":    {string(b)},
+	})
+}
+
+func callSlackAPI(method string, values url.Values) error {
+	res, err := slackHTTPClient.PostForm(slack.SLACK_API+method, values)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	var data struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return err
+	}
+	if !data.Ok {
+		return fmt.Errorf("Failed to call %s: %s", method, data.Error)
+	}
+	return nil
+}
+
+// sendDirectMessage sends the message to the user from the bot, or to the user's own DM if the bot token is not configured
+func (ctx *Context) sendDirectMessage(msg *message) error {
+	token, err := ctx.getSlackAPIToken()
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return errors.New("Slack is not authorized")
+	}
+	return postSlackMessage(token, ctx.UserID, msg)
+}
+
+// getSlackAPIToken returns the bot token, or the user's Slack token if the bot token is not configured
+func (ctx *Context) getSlackAPIToken() (string, error) {
+	if ctx.SlackBotToken != "" {
+		return ctx.SlackBotToken, nil
+	}
+	return ctx.getSlackAccessTokenForUser()
 }
@@ -2,12 +2,17 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/nlopes/slack"
 	"golang.org/x/oauth2"
@@ -39,6 +44,29 @@ func createActionCallbackRequest(callbackID, actionType, token string) *http.Req
 	return req
 }
 
+func createBlockActionCallbackRequest(blockID, actionID, token string) *http.Request {
+	payload := map[string]interface{}{
+		"type":         callbackTypeBlockActions,
+		"token":        token,
+		"team":         map[string]string{"id": "T12345678"},
+		"user":         map[string]string{"id": "FOO"},
+		"response_url": "https://hooks.slack.test/coolhook",
+		"actions": []map[string]string{{
+			"block_id":              blockID,
+			"action_id":             actionID,
+			"value":                 actionID,
+			"selected_conversation": "C1234567",
+		}},
+	}
+	json, _ := json.Marshal(payload)
+	data := url.Values{}
+	data.Set("payload", string(json))
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/interactive", strings.NewReader(data.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
+	return req
+}
+
 func setupActionCallbackGocks(actionType string, responseText string) {
 	if actionType == actionTypeAttend || actionType == actionTypeLeave {
 		gock.New("https://teamspirit-1234.cloudforce.test").
@@ -52,22 +80,28 @@ func setupActionCallbackGocks(actionType string, responseText string) {
 			BodyString(responseText)
 	}
 
-	gock.New("https://teamspirit-1234.cloudforce.test").
-		Get("/services/apexrest/Dakoku").
-		Reply(200).
-		JSON(map[string]interface{}{
-			"timeTable": []map[string]interface{}{{"from": 1, "to": 2, "type": 1}},
-			"isHoliday": false,
-		})
+	setupTimeTableGocks(getActionCallbackTimeTableItems(actionType), &[]bool{false}[0])
+}
+
+// getActionCallbackTimeTableItems returns the time table on which the action can be performed
+func getActionCallbackTimeTableItems(actionType string) []timeTableItem {
+	switch actionType {
+	case actionTypeAttend:
+		return []timeTableItem{}
+	case actionTypeUnrest:
+		return []timeTableItem{{From: null.IntFrom(540), Type: 1}, {From: null.IntFrom(600), Type: 21}}
+	}
+	return []timeTableItem{{From: null.IntFrom(540), Type: 1}}
 }
 
 func testGetActionCallbackWithActionType(t *testing.T, actionType string, successMessage string) {
 	defer gock.Off()
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/interactive", strings.NewReader(""))
 	ctx := app.createContext(req)
 	ctx.UserID = "FOO"
+	ctx.now = getMockTime
 	ctx.setSalesforceAccessToken(&oauth2.Token{
 		AccessToken:  "foo",
 		RefreshToken: "bar",
@@ -79,35 +113,32 @@ func testGetActionCallbackWithActionType(t *testing.T, actionType string, succes
 		Reply(200).
 		JSON([]map[string]interface{}{{"message": "Session expired or invalid", "errorCode": "INVALID_SESSION_ID"}})
 
-	gock.InterceptClient(ctx.createTimeTableClient().HTTPClient)
+	client, _ := ctx.createTimeTableClient(context.Background())
+	gock.InterceptClient(client.HTTPClient)
 
-	msg, responseURL, err := ctx.getActionCallback(&slack.AttachmentActionCallback{
-		Actions:     []slack.AttachmentAction{{Name: actionType}},
+	msg, responseURL, err := ctx.getActionCallback(&actionCallback{
+		ActionName:  actionType,
 		Token:       app.SlackVerificationToken,
 		ResponseURL: "https://hooks.slack.test/coolhook",
-		User: slack.User{
-			ID: "FOO",
-		},
+		UserID:      "FOO",
 	})
 
 	for _, test := range []Test{
 		{true, err == nil},
 		{"https://hooks.slack.test/coolhook", responseURL},
-		{"TeamSpirit で認証を行って、再度 `/ts` コマンドを実行してください :bow:", msg.Attachments[0].Text},
-		{0, strings.Index(msg.Attachments[0].Actions[0].URL, "https://example.com/oauth/salesforce/authenticate/")},
+		{"TeamSpirit で認証を行って、再度 `/ts` コマンドを実行してください :bow:", msg.Blocks[0].Text.Text},
+		{0, strings.Index(msg.getActions()[0].URL, "https://example.com/oauth/salesforce/authenticate/")},
 		{true, gock.IsDone()},
 	} {
 		test.Compare(t)
 	}
 
 	setupActionCallbackGocks(actionType, `"OK"`)
-	msg, responseURL, err = ctx.getActionCallback(&slack.AttachmentActionCallback{
-		Actions:     []slack.AttachmentAction{{Name: actionType}},
+	msg, responseURL, err = ctx.getActionCallback(&actionCallback{
+		ActionName:  actionType,
 		Token:       app.SlackVerificationToken,
 		ResponseURL: "https://hooks.slack.test/coolhook",
-		User: slack.User{
-			ID: "FOO",
-		},
+		UserID:      "FOO",
 	})
 
 	for _, test := range []Test{
@@ -123,13 +154,11 @@ func testGetActionCallbackWithActionType(t *testing.T, actionType string, succes
 
 	setupActionCallbackGocks(actionType, "NG")
 
-	msg, responseURL, err = ctx.getActionCallback(&slack.AttachmentActionCallback{
-		Actions:     []slack.AttachmentAction{{Name: actionType}},
+	msg, responseURL, err = ctx.getActionCallback(&actionCallback{
+		ActionName:  actionType,
 		Token:       app.SlackVerificationToken,
 		ResponseURL: "https://hooks.slack.test/coolhook",
-		User: slack.User{
-			ID: "FOO",
-		},
+		UserID:      "FOO",
 	})
 
 	for _, test := range []Test{
@@ -149,6 +178,65 @@ func TestGetActionCallback(t *testing.T) {
 	testGetActionCallbackWithActionType(t, actionTypeLeave, "退勤しました :house:")
 	testGetActionCallbackWithActionType(t, actionTypeRest, "休憩を開始しました :coffee:")
 	testGetActionCallbackWithActionType(t, actionTypeUnrest, "休憩を終了しました :computer:")
+	testGetActionCallbackWithActionType(t, actionTypeAway, "私用外出を開始しました :walking:")
+
+	// refused without updating TeamSpirit if the time table already has the punch, e.g. when the button of a DM is pressed after punching elsewhere
+	for _, test := range []struct {
+		actionType string
+		items      []timeTableItem
+		expected   string
+	}{
+		{actionTypeAttend, []timeTableItem{{From: null.IntFrom(540), Type: 1}}, "既に出勤済です"},
+		{actionTypeLeave, []timeTableItem{{From: null.IntFrom(540), To: null.IntFrom(600), Type: 1}}, "既に退勤済です。打刻修正は <https://teamspirit-1234.cloudforce.test|TeamSpirit> で行なってください。"},
+		{actionTypeUnrest, []timeTableItem{{From: null.IntFrom(540), Type: 1}, {From: null.IntFrom(570), To: null.IntFrom(600), Type: 21}}, "休憩中ではありません"},
+	} {
+		app := createMockApp()
+		app.CleanStore()
+		ctx := createLoggedInTestContext(app, getMockTime())
+		gock.New("https://teamspirit-1234.cloudforce.test").
+			Put("/services/apexrest/Dakoku").
+			Reply(200).
+			BodyString(`"OK"`)
+		gock.New("https://teamspirit-1234.cloudforce.test").
+			Post("/services/apexrest/Dakoku").
+			Reply(200).
+			BodyString(`"OK"`)
+		setupTimeTableGocks(test.items, &[]bool{false}[0])
+		msg, _, err := ctx.getActionCallback(&actionCallback{
+			ActionName:  test.actionType,
+			ResponseURL: "https://hooks.slack.test/coolhook",
+			UserID:      "FOO",
+		})
+		for _, test := range []Test{
+			{nil, err},
+			{test.expected, msg.Text},
+			{2, len(gock.Pending())},
+		} {
+			test.Compare(t)
+		}
+		gock.Off()
+	}
+}
+
+func TestGetActionCallbackUnknownAction(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := app.createContext(nil)
+	setupTimeTableGocks([]timeTableItem{}, &[]bool{false}[0])
+	msg, responseURL, err := ctx.getActionCallback(&actionCallback{
+		ActionName:  actionTypeAuthenticate,
+		ResponseURL: "https://hooks.slack.test/coolhook",
+		UserID:      "FOO",
+	})
+	for _, test := range []Test{
+		{true, msg == nil},
+		{"https://hooks.slack.test/coolhook", responseURL},
+		{"Unknown action: authenticate", err.Error()},
+		{true, gock.IsPending()},
+	} {
+		test.Compare(t)
+	}
 }
 
 func setupTimeTableGocks(items []timeTableItem, isHoliday *bool) {
@@ -170,8 +258,8 @@ func TestGetSlackMessage(t *testing.T) {
 	msg, err := ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678"})
 	for _, test := range []Test{
 		{true, err == nil},
-		{"TeamSpirit で認証を行って、再度 `/ts` コマンドを実行してください :bow:", msg.Attachments[0].Text},
-		{0, strings.Index(msg.Attachments[0].Actions[0].URL, "https://example.com/oauth/salesforce/authenticate/")},
+		{"TeamSpirit で認証を行って、再度 `/ts` コマンドを実行してください :bow:", msg.Blocks[0].Text.Text},
+		{0, strings.Index(msg.getActions()[0].URL, "https://example.com/oauth/salesforce/authenticate/")},
 	} {
 		test.Compare(t)
 	}
@@ -180,12 +268,30 @@ func TestGetSlackMessage(t *testing.T) {
 		RefreshToken: "bar",
 		TokenType:    "Bearer",
 	})
+	gock.New("https://teamspirit-1234.cloudforce.test").
+		Get("/services/apexrest/Dakoku").
+		Reply(401)
+	ctx.TimeTableClient = nil
+	msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678"})
+	for _, test := range []Test{
+		{true, err == nil},
+		{"TeamSpirit で認証を行って、再度 `/ts` コマンドを実行してください :bow:", msg.Blocks[0].Text.Text},
+		{0, strings.Index(msg.getActions()[0].URL, "https://example.com/oauth/salesforce/authenticate/")},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+	gock.New("https://teamspirit-1234.cloudforce.test").
+		Get("/services/apexrest/Dakoku").
+		Reply(500).
+		JSON([]map[string]interface{}{{"message": "System.NullPointerException", "errorCode": "APEX_ERROR"}})
 	ctx.TimeTableClient = nil
 	msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678"})
 	for _, test := range []Test{
 		{true, err == nil},
-		{"TeamSpirit で認証を行って、再度 `/ts` コマンドを実行してください :bow:", msg.Attachments[0].Text},
-		{0, strings.Index(msg.Attachments[0].Actions[0].URL, "https://example.com/oauth/salesforce/authenticate/")},
+		{"ephemeral", msg.ResponseType},
+		{"TeamSpirit でエラーが発生しました: System.NullPointerException (APEX_ERROR) :warning:", msg.Text},
+		{true, gock.IsDone()},
 	} {
 		test.Compare(t)
 	}
@@ -196,8 +302,8 @@ func TestGetSlackMessage(t *testing.T) {
 	msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", Text: "channel"})
 	for _, test := range []Test{
 		{true, err == nil},
-		{"Slack で認証を行って、再度 `/ts channel` コマンドを実行してください :bow:", msg.Attachments[0].Text},
-		{0, strings.Index(msg.Attachments[0].Actions[0].URL, "https://example.com/oauth/slack/authenticate/T12345678/")},
+		{"Slack で認証を行って、再度 `/ts channel` コマンドを実行してください :bow:", msg.Blocks[0].Text.Text},
+		{0, strings.Index(msg.getActions()[0].URL, "https://example.com/oauth/slack/authenticate/T12345678/")},
 	} {
 		test.Compare(t)
 	}
@@ -209,7 +315,10 @@ func TestGetSlackMessage(t *testing.T) {
 	msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", Text: "channel"})
 	for _, test := range []Test{
 		{true, err == nil},
-		{"打刻時に通知するチャネルを選択して下さい", msg.Attachments[0].Text},
+		{"打刻時に通知するチャネルを選択して下さい", msg.Blocks[0].Text.Text},
+		{"conversations_select", msg.getActions()[0].Type},
+		{actionTypeSelectChannel, msg.getActions()[0].ActionID},
+		{actionTypeUnselectChannel, msg.getActions()[1].ActionID},
 	} {
 		test.Compare(t)
 	}
@@ -233,22 +342,22 @@ func TestGetSlackMessage(t *testing.T) {
 	msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678"})
 	for _, test := range []Test{
 		{true, err == nil},
-		{"休憩を終了する", msg.Attachments[0].Actions[0].Text},
-		{actionTypeUnrest, msg.Attachments[0].Actions[0].Name},
+		{"休憩を終了する", msg.getActions()[0].Text.Text},
+		{actionTypeUnrest, msg.getActions()[0].ActionID},
 		{true, gock.IsDone()},
 	} {
 		test.Compare(t)
 	}
 	setupTimeTableGocks([]timeTableItem{
 		{null.IntFrom(10 * 60), null.IntFromPtr(nil), 1},
-		{null.IntFrom(10 * 60), null.IntFromPtr(nil), 21},
+		{null.IntFrom(10 * 60), null.IntFromPtr(nil), 22},
 	}, &[]bool{false}[0])
 	ctx.TimeTableClient = nil
 	msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678"})
 	for _, test := range []Test{
 		{true, err == nil},
-		{"休憩を終了する", msg.Attachments[0].Actions[0].Text},
-		{actionTypeUnrest, msg.Attachments[0].Actions[0].Name},
+		{"私用外出から戻る", msg.getActions()[0].Text.Text},
+		{actionTypeUnrest, msg.getActions()[0].ActionID},
 		{true, gock.IsDone()},
 	} {
 		test.Compare(t)
@@ -258,13 +367,17 @@ func TestGetSlackMessage(t *testing.T) {
 		{null.IntFrom(10 * 60), null.IntFrom(11 * 60), 21},
 	}, &[]bool{false}[0])
 	ctx.TimeTableClient = nil
+	ctx.now = getMockTime
 	msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678"})
 	for _, test := range []Test{
 		{true, err == nil},
-		{"休憩を開始する", msg.Attachments[0].Actions[0].Text},
-		{actionTypeRest, msg.Attachments[0].Actions[0].Name},
-		{"退勤する", msg.Attachments[0].Actions[1].Text},
-		{actionTypeLeave, msg.Attachments[0].Actions[1].Name},
+		{"*出勤* 10:00\n*休憩* 10:00 - 11:00 (1時間)\n*勤務時間* 12分", msg.Blocks[0].Text.Text},
+		{"休憩を開始する", msg.getActions()[0].Text.Text},
+		{actionTypeRest, msg.getActions()[0].ActionID},
+		{"私用外出する", msg.getActions()[1].Text.Text},
+		{actionTypeAway, msg.getActions()[1].ActionID},
+		{"退勤する", msg.getActions()[2].Text.Text},
+		{actionTypeLeave, msg.getActions()[2].ActionID},
 		{true, gock.IsDone()},
 	} {
 		test.Compare(t)
@@ -277,8 +390,8 @@ func TestGetSlackMessage(t *testing.T) {
 	msg, err = ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678"})
 	for _, test := range []Test{
 		{true, err == nil},
-		{"出勤する", msg.Attachments[0].Actions[0].Text},
-		{actionTypeAttend, msg.Attachments[0].Actions[0].Name},
+		{"出勤する", msg.getActions()[0].Text.Text},
+		{actionTypeAttend, msg.getActions()[0].ActionID},
 		{true, gock.IsDone()},
 	} {
 		test.Compare(t)
@@ -294,3 +407,76 @@ func TestGetSlackMessage(t *testing.T) {
 		test.Compare(t)
 	}
 }
+
+func TestGetTimeTableErrorSlackMessage(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/slash", bytes.NewBufferString(""))
+	ctx := app.createContext(req)
+	state := State{TeamID: "T12345678", UserID: "FOO"}
+	msg, err := ctx.getTimeTableErrorSlackMessage(state, errUnauthorized)
+	for _, test := range []Test{
+		{true, err == nil},
+		{"TeamSpirit で認証を行って、再度 `/ts` コマンドを実行してください :bow:", msg.Blocks[0].Text.Text},
+	} {
+		test.Compare(t)
+	}
+	msg, err = ctx.getTimeTableErrorSlackMessage(state, errRefreshTokenRevoked)
+	for _, test := range []Test{
+		{true, err == nil},
+		{"TeamSpirit の認証が取り消されたか、有効期限が切れました", msg.Blocks[0].Elements[0].(*textObject).Text},
+		{"TeamSpirit で認証を行って、再度 `/ts` コマンドを実行してください :bow:", msg.Blocks[1].Text.Text},
+	} {
+		test.Compare(t)
+	}
+	msg, err = ctx.getTimeTableErrorSlackMessage(state, errTimeout)
+	for _, test := range []Test{
+		{true, err == nil},
+		{"ephemeral", msg.ResponseType},
+		{"TeamSpirit から応答がありませんでした。しばらくしてから再度お試しください :hourglass:", msg.Text},
+	} {
+		test.Compare(t)
+	}
+	msg, _ = ctx.getTimeTableErrorSlackMessage(state, errors.New("foo"))
+	Test{"TeamSpirit との通信に失敗しました :warning:", msg.Text}.Compare(t)
+	for _, test := range []Test{
+		{"勤務表の更新に失敗しました :warning:", getPunchFailedText(nil)},
+		{"TeamSpirit の認証が切れました。`/ts login` で再度認証してください :bow:", getPunchFailedText(errUnauthorized)},
+		{"TeamSpirit から応答がありませんでした。しばらくしてから再度お試しください :hourglass:", getPunchFailedText(errTimeout)},
+		{"勤務表が TeamSpirit で更新されたため、打刻できませんでした。再度お試しください :warning:", getPunchFailedText(errConflict)},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestCallSlackAPITimeout(t *testing.T) {
+	done := make(chan bool)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+	// slack.com is served by the server which does not respond
+	transport := server.Client().Transport.(*http.Transport)
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return net.Dial(network, server.Listener.Addr().String())
+	}
+	defaultTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = defaultTransport }()
+	http.DefaultTransport = transport
+	defer func(timeout time.Duration) { slackHTTPClient.Timeout = timeout }(slackHTTPClient.Timeout)
+	slackHTTPClient.Timeout = 50 * time.Millisecond
+	started := time.Now()
+	err := postSlackMessage("baz", "FOO", getEphemeralSlackMessage("foo"))
+	netErr, ok := err.(net.Error)
+	for _, test := range []Test{
+		{true, ok && netErr.Timeout()},
+		{true, time.Since(started) < time.Second},
+	} {
+		test.Compare(t)
+	}
+}
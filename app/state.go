@@ -2,8 +2,7 @@ package app
 
 import (
 	"encoding/json"
-
-	"github.com/garyburd/redigo/redis"
+	"time"
 )
 
 // State state for authentication
@@ -11,39 +10,75 @@ type State struct {
 	UserID      string `json:"u,omitempty"`
 	TeamID      string `json:"t,omitempty"`
 	ResponseURL string `json:"r,omitempty"`
+	ExpiresAt   int64  `json:"e,omitempty"`
 }
 
-func (ctx *Context) getState(state string) *State {
-	res, err := ctx.RedisConn.Do("HGET", ctx.StateStoreKey, state)
-	if err != nil {
+// IsExpired returns if the state can no longer be used
+func (state *State) IsExpired(now time.Time) bool {
+	return now.Unix() >= state.ExpiresAt
+}
+
+func parseState(data string) *State {
+	if data == "" {
 		return nil
 	}
-	if data, ok := res.([]byte); ok {
-		var res State
-		json.Unmarshal(data, &res)
-		return &res
+	var res State
+	json.Unmarshal([]byte(data), &res)
+	if res.IsExpired(time.Now()) {
+		return nil
 	}
-	return nil
+	return &res
+}
+
+func (ctx *Context) getState(state string) (*State, error) {
+	data, err := ctx.Store.Get(ctx.StateStoreKey, state)
+	if err != nil {
+		return nil, err
+	}
+	return parseState(data), nil
+}
+
+// consumeState removes the state from the store and returns it, so that the same state cannot be used twice
+func (ctx *Context) consumeState(state string) (*State, error) {
+	data, err := ctx.Store.Take(ctx.StateStoreKey, state)
+	if err != nil {
+		return nil, err
+	}
+	return parseState(data), nil
 }
 
 func (ctx *Context) storeState(state State) (string, error) {
 	state.UserID = ctx.UserID
-	stateKey := ctx.generateState()
+	state.ExpiresAt = time.Now().Add(ctx.StateTTL).Unix()
 	jsonData, _ := json.Marshal(state)
-	_, err := redis.Bool(ctx.RedisConn.Do("HSET", ctx.StateStoreKey, stateKey, string(jsonData)))
-	return stateKey, err
+	for {
+		stateKey := ctx.randomString(24)
+		set, err := ctx.Store.SetIfAbsent(ctx.StateStoreKey, stateKey, string(jsonData))
+		if err != nil || set {
+			return stateKey, err
+		}
+	}
 }
 
 func (ctx *Context) deleteState(state string) error {
-	_, err := ctx.RedisConn.Do("HDEL", ctx.StateStoreKey, state)
-	return err
+	return ctx.Store.Delete(ctx.StateStoreKey, state)
 }
 
-func (ctx *Context) generateState() string {
-	state := ctx.randomString(24)
-	exists, _ := redis.Bool(ctx.RedisConn.Do("HEXISTS", ctx.StateStoreKey, state))
-	if exists {
-		return ctx.generateState()
+// purgeExpiredStates deletes the states which were never consumed
+func (ctx *Context) purgeExpiredStates() error {
+	states, err := ctx.Store.All(ctx.StateStoreKey)
+	if err != nil {
+		return err
 	}
-	return state
+	now := ctx.now()
+	for key, data := range states {
+		var state State
+		if json.Unmarshal([]byte(data), &state) == nil && !state.IsExpired(now) {
+			continue
+		}
+		if err := ctx.deleteState(key); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -2,12 +2,14 @@ package app
 
 import (
 	"fmt"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestState(t *testing.T) {
 	app := createMockApp()
-	app.CleanRedis()
+	app.CleanStore()
 	ctx := app.createContext(nil)
 	ctx.UserID = "FOO"
 	var callCount = 0
@@ -15,21 +17,85 @@ func TestState(t *testing.T) {
 		callCount++
 		return fmt.Sprintf("random-%d-%d", len, callCount)
 	}
-	ctx.RedisConn.Do("HSET", ctx.StateStoreKey, "random-24-1", "BAR")
+	ctx.Store.Set(ctx.StateStoreKey, "random-24-1", `{"e":`+strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)+`}`)
 	state, err := ctx.storeState(State{TeamID: "T123456", UserID: "FOO", ResponseURL: "http://foo.com/bar"})
+	stored, getErr := ctx.getState(state)
 	for _, test := range []Test{
 		{true, err == nil},
+		{true, getErr == nil},
 		{"random-24-2", state},
-		{"FOO", ctx.getState(state).UserID},
-		{"T123456", ctx.getState(state).TeamID},
-		{"http://foo.com/bar", ctx.getState(state).ResponseURL},
+		{"FOO", stored.UserID},
+		{"T123456", stored.TeamID},
+		{"http://foo.com/bar", stored.ResponseURL},
+		{time.Now().Add(10 * time.Minute).Unix(), stored.ExpiresAt},
 	} {
 		test.Compare(t)
 	}
 	err = ctx.deleteState(state)
+	stored, getErr = ctx.getState(state)
 	for _, test := range []Test{
 		{true, err == nil},
-		{true, ctx.getState(state) == nil},
+		{true, getErr == nil},
+		{true, stored == nil},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestConsumeState(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	ctx := app.createContext(nil)
+	ctx.UserID = "FOO"
+	state, _ := ctx.storeState(State{TeamID: "T123456"})
+	consumed, err := ctx.consumeState(state)
+	for _, test := range []Test{
+		{true, err == nil},
+		{"FOO", consumed.UserID},
+		{"T123456", consumed.TeamID},
+	} {
+		test.Compare(t)
+	}
+	consumed, err = ctx.consumeState(state)
+	for _, test := range []Test{
+		{true, err == nil},
+		{true, consumed == nil},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestExpiredState(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	ctx := app.createContext(nil)
+	ctx.UserID = "FOO"
+	ctx.StateTTL = -time.Minute
+	expired, _ := ctx.storeState(State{TeamID: "T123456"})
+	stored, err := ctx.getState(expired)
+	for _, test := range []Test{
+		{true, err == nil},
+		{true, stored == nil},
+	} {
+		test.Compare(t)
+	}
+	stored, err = ctx.consumeState(expired)
+	for _, test := range []Test{
+		{true, err == nil},
+		{true, stored == nil},
+	} {
+		test.Compare(t)
+	}
+	ctx.Store.Set(ctx.StateStoreKey, "legacy", `{"u":"FOO"}`)
+	expired, _ = ctx.storeState(State{TeamID: "T123456"})
+	ctx.StateTTL = time.Minute
+	state, _ := ctx.storeState(State{TeamID: "T123456"})
+	Test{nil, ctx.purgeExpiredStates()}.Compare(t)
+	states, _ := ctx.Store.All(ctx.StateStoreKey)
+	_, ok := states[state]
+	for _, test := range []Test{
+		{1, len(states)},
+		{true, ok},
 	} {
 		test.Compare(t)
 	}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// Store persists string values in named hashes
+type Store interface {
+	Get(hashKey, field string) (string, error)
+	Set(hashKey, field, value string) error
+	// SetIfAbsent sets the value only if the field does not exist and returns whether it was set, atomically
+	SetIfAbsent(hashKey, field, value string) (bool, error)
+	Delete(hashKey, field string) error
+	Take(hashKey, field string) (string, error)
+	All(hashKey string) (map[string]string, error)
+}
+
+func newStore(storeURL string) (Store, error) {
+	if storeURL == "" {
+		return newRedisStore("")
+	}
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		return newRedisStore(storeURL)
+	case "memory":
+		return newMemoryStore(), nil
+	case "bolt":
+		return newBoltStore(u.Host + u.Path)
+	}
+	return nil, fmt.Errorf("Unsupported store URL: %s", storeURL)
+}
+
+func (app *App) setupStore() error {
+	storeURL := os.Getenv("STORE_URL")
+	if storeURL == "" {
+		storeURL = os.Getenv("REDIS_URL")
+	}
+	store, err := newStore(storeURL)
+	if err != nil {
+		return err
+	}
+	app.Store = store
+	return nil
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"testing"
+)
+
+func testStore(t *testing.T, store Store) {
+	hashKey := "tsdakoku-test:store"
+	store.Delete(hashKey, "foo")
+	store.Delete(hashKey, "bar")
+	value, err := store.Get(hashKey, "foo")
+	for _, test := range []Test{
+		{true, err == nil},
+		{"", value},
+	} {
+		test.Compare(t)
+	}
+	err = store.Set(hashKey, "foo", "FOO")
+	value, _ = store.Get(hashKey, "foo")
+	for _, test := range []Test{
+		{true, err == nil},
+		{"FOO", value},
+	} {
+		test.Compare(t)
+	}
+	set, err := store.SetIfAbsent(hashKey, "foo", "FOO2")
+	value, _ = store.Get(hashKey, "foo")
+	for _, test := range []Test{
+		{true, err == nil},
+		{false, set},
+		{"FOO", value},
+	} {
+		test.Compare(t)
+	}
+	set, err = store.SetIfAbsent(hashKey, "bar", "BAR")
+	value, _ = store.Get(hashKey, "bar")
+	for _, test := range []Test{
+		{true, err == nil},
+		{true, set},
+		{"BAR", value},
+	} {
+		test.Compare(t)
+	}
+	values, err := store.All(hashKey)
+	for _, test := range []Test{
+		{true, err == nil},
+		{2, len(values)},
+		{"FOO", values["foo"]},
+		{"BAR", values["bar"]},
+	} {
+		test.Compare(t)
+	}
+	value, err = store.Take(hashKey, "bar")
+	values, _ = store.All(hashKey)
+	_, ok := values["bar"]
+	for _, test := range []Test{
+		{true, err == nil},
+		{"BAR", value},
+		{false, ok},
+	} {
+		test.Compare(t)
+	}
+	value, err = store.Take(hashKey, "bar")
+	for _, test := range []Test{
+		{true, err == nil},
+		{"", value},
+	} {
+		test.Compare(t)
+	}
+	err = store.Delete(hashKey, "foo")
+	values, _ = store.All(hashKey)
+	_, ok = values["foo"]
+	for _, test := range []Test{
+		{true, err == nil},
+		{false, ok},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	store, err := newStore("memory://")
+	_, ok := store.(*memoryStore)
+	for _, test := range []Test{
+		{true, err == nil},
+		{true, ok},
+	} {
+		test.Compare(t)
+	}
+	store, err = newStore("foo://bar")
+	for _, test := range []Test{
+		{true, store == nil},
+		{"Unsupported store URL: foo://bar", err.Error()},
+	} {
+		test.Compare(t)
+	}
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+)
+
+func formatMinutes(minutes int64) string {
+	return fmt.Sprintf("%d:%02d", minutes/60, minutes%60)
+}
+
+func formatDuration(minutes int64) string {
+	if minutes < 0 {
+		minutes = 0
+	}
+	if minutes < 60 {
+		return fmt.Sprintf("%d分", minutes)
+	}
+	if minutes%60 == 0 {
+		return fmt.Sprintf("%d時間", minutes/60)
+	}
+	return fmt.Sprintf("%d時間%d分", minutes/60, minutes%60)
+}
+
+func (item *timeTableItem) getLabel() string {
+	if item.IsAway() {
+		return "私用外出"
+	}
+	if item.IsRest() {
+		return "休憩"
+	}
+	return "勤務"
+}
+
+// getDuration returns the minutes of the item, counting open items until now
+func (item *timeTableItem) getDuration(now int64) int64 {
+	if !item.From.Valid {
+		return 0
+	}
+	to := now
+	if item.To.Valid {
+		to = item.To.Int64
+	}
+	if to < item.From.Int64 {
+		return 0
+	}
+	return to - item.From.Int64
+}
+
+func (tt *timeTable) getAttendance() *timeTableItem {
+	for i, item := range tt.Items {
+		if item.IsAttendance() {
+			return &tt.Items[i]
+		}
+	}
+	return nil
+}
+
+// GetWorkingMinutes returns working minutes excluding rests, counting open items until now
+func (tt *timeTable) GetWorkingMinutes(now int64) int64 {
+	attendance := tt.getAttendance()
+	if attendance == nil || !attendance.From.Valid {
+		return 0
+	}
+	minutes := attendance.getDuration(now)
+	end := now
+	if attendance.To.Valid {
+		end = attendance.To.Int64
+	}
+	for _, item := range tt.Items {
+		if !item.IsRest() || !item.From.Valid {
+			continue
+		}
+		from := item.From.Int64
+		to := end
+		if item.To.Valid && item.To.Int64 < end {
+			to = item.To.Int64
+		}
+		if from < attendance.From.Int64 {
+			from = attendance.From.Int64
+		}
+		if to > from {
+			minutes -= to - from
+		}
+	}
+	if minutes < 0 {
+		return 0
+	}
+	return minutes
+}
+
+// GetSummary returns today's timeline as Slack mrkdwn text
+func (tt *timeTable) GetSummary(now int64) string {
+	attendance := tt.getAttendance()
+	if attendance == nil || !attendance.From.Valid {
+		return ""
+	}
+	lines := []string{"*出勤* " + formatMinutes(attendance.From.Int64)}
+	for _, item := range tt.Items {
+		if !item.IsRest() || !item.From.Valid {
+			continue
+		}
+		if item.To.Valid {
+			lines = append(lines, fmt.Sprintf("*%s* %s - %s (%s)", item.getLabel(), formatMinutes(item.From.Int64), formatMinutes(item.To.Int64), formatDuration(item.getDuration(now))))
+		} else {
+			lines = append(lines, fmt.Sprintf("*%s* %s - (%s経過)", item.getLabel(), formatMinutes(item.From.Int64), formatDuration(item.getDuration(now))))
+		}
+	}
+	lines = append(lines, "*勤務時間* "+formatDuration(tt.GetWorkingMinutes(now)))
+	if attendance.To.Valid {
+		lines = append(lines, "*退勤* "+formatMinutes(attendance.To.Int64))
+	} else if tt.StdEndTime != nil {
+		lines = append(lines, "*退勤予定* "+formatMinutes(*tt.StdEndTime))
+	}
+	return strings.Join(lines, "\n")
+}
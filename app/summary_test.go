@@ -0,0 +1,74 @@
+package app
+
+import (
+	"testing"
+
+	null "gopkg.in/guregu/null.v3"
+)
+
+func TestFormatMinutes(t *testing.T) {
+	for _, test := range []Test{
+		{"0:00", formatMinutes(0)},
+		{"9:05", formatMinutes(545)},
+		{"18:30", formatMinutes(1110)},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	for _, test := range []Test{
+		{"0分", formatDuration(-1)},
+		{"45分", formatDuration(45)},
+		{"1時間", formatDuration(60)},
+		{"8時間15分", formatDuration(495)},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestGetWorkingMinutes(t *testing.T) {
+	tt := timeTable{
+		Items: []timeTableItem{
+			{From: null.IntFrom(9 * 60), Type: 1},
+			{From: null.IntFrom(12 * 60), To: null.IntFrom(13 * 60), Type: 21},
+			{From: null.IntFrom(15 * 60), Type: 22},
+		},
+	}
+	for _, test := range []Test{
+		{int64(0), (&timeTable{}).GetWorkingMinutes(600)},
+		{int64(3 * 60), tt.GetWorkingMinutes(12 * 60)},
+		{int64(3 * 60), tt.GetWorkingMinutes(13 * 60)},
+		{int64(5 * 60), tt.GetWorkingMinutes(16 * 60)},
+	} {
+		test.Compare(t)
+	}
+	tt.Items[2].To = null.IntFrom(15*60 + 30)
+	tt.Items[0].To = null.IntFrom(18 * 60)
+	Test{int64(7*60 + 30), tt.GetWorkingMinutes(20 * 60)}.Compare(t)
+}
+
+func TestGetSummary(t *testing.T) {
+	stdEndTime := int64(18 * 60)
+	tt := timeTable{
+		Items: []timeTableItem{
+			{From: null.IntFrom(9*60 + 5), Type: 1},
+			{From: null.IntFrom(12 * 60), To: null.IntFrom(13 * 60), Type: 21},
+			{From: null.IntFrom(15 * 60), Type: 22},
+		},
+		StdEndTime: &stdEndTime,
+	}
+	Test{"", (&timeTable{}).GetSummary(600)}.Compare(t)
+	Test{"*出勤* 9:05\n" +
+		"*休憩* 12:00 - 13:00 (1時間)\n" +
+		"*私用外出* 15:00 - (20分経過)\n" +
+		"*勤務時間* 4時間55分\n" +
+		"*退勤予定* 18:00", tt.GetSummary(15*60 + 20)}.Compare(t)
+	tt.Items[2].To = null.IntFrom(15*60 + 30)
+	tt.Items[0].To = null.IntFrom(18*60 + 30)
+	Test{"*出勤* 9:05\n" +
+		"*休憩* 12:00 - 13:00 (1時間)\n" +
+		"*私用外出* 15:00 - 15:30 (30分)\n" +
+		"*勤務時間* 7時間55分\n" +
+		"*退勤* 18:30", tt.GetSummary(20 * 60)}.Compare(t)
+}
@@ -2,21 +2,42 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"net/url"
 	"time"
 
 	"gopkg.in/guregu/null.v3"
 )
 
 type timeTable struct {
-	Items     []timeTableItem `json:"timeTable"`
-	IsHoliday *bool           `json:"isHoliday,omitempty"`
+	Items      []timeTableItem `json:"timeTable"`
+	IsHoliday  *bool           `json:"isHoliday,omitempty"`
+	StdEndTime *int64          `json:"stdEndTime,omitempty"`
+	// LastModifiedDate is sent back on update to detect edits made after the time table was fetched
+	LastModifiedDate string `json:"lastModifiedDate,omitempty"`
+	// Date is the work day of the time table formatted as timeTableDateLayout
+	Date string `json:"date,omitempty"`
 }
 
+const timeTableDateLayout = "2006-01-02"
+
+// overnightWindow is how long after midnight the previous day's time table is used while it is not left,
+// so that a user who forgot to clock out is not stuck on it the next day
+const overnightWindow = 6 * time.Hour
+
+const (
+	timeTableItemTypeAttendance = 1
+	timeTableItemTypeRest       = 21
+	// timeTableItemTypeAway is a private outing (私用外出)
+	timeTableItemTypeAway = 22
+)
+
 type timeTableItem struct {
 	From null.Int `json:"from,omitempty"`
 	To   null.Int `json:"to,omitempty"`
@@ -24,19 +45,34 @@ type timeTableItem struct {
 }
 
 type timeTableError struct {
-	Message string `json:"message"`
-	Code    string `json:"errorCode"`
+	Message    string `json:"message"`
+	Code       string `json:"errorCode"`
+	StatusCode int    `json:"-"`
 }
 
+// defaultTeamSpiritTimeout is the deadline of each request to TeamSpirit
+const defaultTeamSpiritTimeout = 30 * time.Second
+
 type timeTableClient struct {
-	HTTPClient *http.Client
-	Endpoint   string
+	HTTPClient    *http.Client
+	Endpoint      string
+	Timeout       time.Duration
+	MaxRetries    int
+	RetryInterval time.Duration
 }
 
-func parseTimeTable(body []byte) (*timeTable, error) {
+// parseTimeTableError returns the first error in the Salesforce REST API error response, or nil
+func parseTimeTableError(body []byte) error {
 	var errors []timeTableError
 	if err := json.Unmarshal(body, &errors); err == nil && len(errors) > 0 && errors[0].Code != "" {
-		return nil, fmt.Errorf("Error: %+v (%+v)", errors[0].Message, errors[0].Code)
+		return &errors[0]
+	}
+	return nil
+}
+
+func parseTimeTable(body []byte) (*timeTable, error) {
+	if err := parseTimeTableError(body); err != nil {
+		return nil, err
 	}
 	var timeTable timeTable
 	if err := json.Unmarshal(body, &timeTable); err != nil {
@@ -50,12 +86,40 @@ func convertTime(time time.Time) null.Int {
 	return null.IntFrom(int64(hour*60 + min))
 }
 
+// convertTime returns minutes from 00:00 of the work day, which exceeds 1440 after midnight
+func (tt *timeTable) convertTime(t time.Time) null.Int {
+	minutes := convertTime(t)
+	if tt.Date == "" {
+		return minutes
+	}
+	day, err := time.ParseInLocation(timeTableDateLayout, tt.Date, t.Location())
+	if err != nil {
+		return minutes
+	}
+	year, month, date := t.Date()
+	today := time.Date(year, month, date, 0, 0, 0, 0, t.Location())
+	days := int64(math.Round(today.Sub(day).Hours() / 24))
+	return null.IntFrom(days*24*60 + minutes.Int64)
+}
+
+// resolveClock returns the latest minutes of the work day at the clock which is not after now
+func resolveClock(minutes int64, now int64) int64 {
+	if minutes >= 24*60 || now < minutes {
+		return minutes
+	}
+	return minutes + (now-minutes)/(24*60)*24*60
+}
+
 func (item *timeTableItem) IsAttendance() bool {
-	return item.Type == 1
+	return item.Type == timeTableItemTypeAttendance
 }
 
 func (item *timeTableItem) IsRest() bool {
-	return item.Type == 21 || item.Type == 22
+	return item.Type == timeTableItemTypeRest || item.Type == timeTableItemTypeAway
+}
+
+func (item *timeTableItem) IsAway() bool {
+	return item.Type == timeTableItemTypeAway
 }
 
 func (tt *timeTable) IsAttending() bool {
@@ -89,101 +153,200 @@ func (tt *timeTable) Attend(time time.Time) bool {
 	items := tt.Items
 	for i, item := range items {
 		if item.IsAttendance() {
-			items[i].From = convertTime(time)
+			items[i].From = tt.convertTime(time)
 			tt.Items = items
 			return true
 		}
 	}
 	tt.Items = append(tt.Items, timeTableItem{
-		From: convertTime(time),
+		From: tt.convertTime(time),
 		Type: 1,
 	})
 	return true
 }
 
 func (tt *timeTable) Rest(time time.Time) bool {
+	return tt.AddRest(tt.convertTime(time), null.Int{})
+}
+
+// AddRest appends a rest starting at from, which is closed if to is valid
+func (tt *timeTable) AddRest(from null.Int, to null.Int) bool {
+	return tt.addRestItem(from, to, timeTableItemTypeRest)
+}
+
+func (tt *timeTable) Away(time time.Time) bool {
+	return tt.AddAway(tt.convertTime(time), null.Int{})
+}
+
+// AddAway appends a private outing starting at from, which is closed if to is valid
+func (tt *timeTable) AddAway(from null.Int, to null.Int) bool {
+	return tt.addRestItem(from, to, timeTableItemTypeAway)
+}
+
+func (tt *timeTable) addRestItem(from null.Int, to null.Int, itemType int) bool {
 	tt.Items = append(tt.Items, timeTableItem{
-		From: convertTime(time),
-		Type: 21,
+		From: from,
+		To:   to,
+		Type: itemType,
 	})
 	return true
 }
 
-func (tt *timeTable) Unrest(time time.Time) bool {
-	items := tt.Items
-	for i, item := range items {
-		if item.IsRest() && !item.To.Valid {
-			items[i].To = convertTime(time)
-			tt.Items = items
-			return true
+// getOpenRest returns the latest rest or private outing which is not closed yet
+func (tt *timeTable) getOpenRest() *timeTableItem {
+	for i := len(tt.Items) - 1; i >= 0; i-- {
+		if item := &tt.Items[i]; item.IsRest() && !item.To.Valid {
+			return item
 		}
 	}
+	return nil
+}
+
+func (tt *timeTable) Unrest(time time.Time) bool {
+	return tt.UnrestAt(tt.convertTime(time))
+}
+
+// UnrestAt closes the open rest or private outing at the minutes
+func (tt *timeTable) UnrestAt(to null.Int) bool {
+	if item := tt.getOpenRest(); item != nil {
+		item.To = to
+		return true
+	}
 	tt.Items = append(tt.Items, timeTableItem{
-		To:   convertTime(time),
-		Type: 21,
+		To:   to,
+		Type: timeTableItemTypeRest,
 	})
 	return true
-
 }
 
 func (tt *timeTable) Leave(time time.Time) bool {
 	items := tt.Items
 	for i, item := range items {
 		if item.Type == 1 {
-			items[i].To = convertTime(time)
+			items[i].To = tt.convertTime(time)
 			tt.Items = items
 			return true
 		}
 	}
 	tt.Items = append(tt.Items, timeTableItem{
-		To:   convertTime(time),
+		To:   tt.convertTime(time),
 		Type: 1,
 	})
 	return true
 }
 
-func (ctx *Context) createTimeTableClient() *timeTableClient {
+func (ctx *Context) createTimeTableClient(reqCtx context.Context) (*timeTableClient, error) {
 	if ctx.TimeTableClient != nil {
-		return ctx.TimeTableClient
+		return ctx.TimeTableClient, nil
+	}
+	httpClient, err := ctx.getSalesforceOAuth2Client(reqCtx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := ctx.getSalesforceTokenForUser()
+	if err != nil {
+		return nil, err
+	}
+	instanceURL := "https://" + ctx.TeamSpiritHost
+	if token != nil && token.InstanceURL != "" {
+		instanceURL = token.InstanceURL
 	}
 	ctx.TimeTableClient = &timeTableClient{
-		HTTPClient: ctx.getSalesforceOAuth2Client(),
-		Endpoint:   "https://" + ctx.TeamSpiritHost + "/services/apexrest/Dakoku",
+		HTTPClient:    httpClient,
+		Endpoint:      instanceURL + "/services/apexrest/Dakoku",
+		Timeout:       ctx.TeamSpiritTimeout,
+		MaxRetries:    defaultTimeTableMaxRetries,
+		RetryInterval: defaultTimeTableRetryInterval,
 	}
-	return ctx.TimeTableClient
+	return ctx.TimeTableClient, nil
 }
 
-func (client *timeTableClient) doRequest(method string, data io.Reader) ([]byte, error) {
-	req, err := http.NewRequest(method, client.Endpoint, data)
+func (client *timeTableClient) doRequest(reqCtx context.Context, method string, params url.Values, data io.Reader) ([]byte, error) {
+	if client.Timeout > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(reqCtx, client.Timeout)
+		defer cancel()
+	}
+	endpoint := client.Endpoint
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+	req, err := http.NewRequest(method, endpoint, data)
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(reqCtx)
 	if data != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
 	res, err := client.HTTPClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, classifyRequestError(err)
 	}
+	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
-	return body, err
+	if err != nil {
+		return nil, classifyRequestError(err)
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		return nil, errUnauthorized
+	}
+	if res.StatusCode == http.StatusConflict {
+		return nil, errConflict
+	}
+	if err := parseTimeTableError(body); err != nil {
+		err.(*timeTableError).StatusCode = res.StatusCode
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, &timeTableError{
+			Message:    res.Status,
+			Code:       httpErrorCode,
+			StatusCode: res.StatusCode,
+		}
+	}
+	return body, nil
+}
+
+func (client *timeTableClient) GetTimeTable(reqCtx context.Context) (*timeTable, error) {
+	return client.GetTimeTableOn(reqCtx, "")
 }
 
-func (client *timeTableClient) GetTimeTable() (*timeTable, error) {
-	body, err := client.doRequest(http.MethodGet, nil)
+// GetTimeTableOn returns the time table of the date formatted as timeTableDateLayout, or today's if date is empty
+func (client *timeTableClient) GetTimeTableOn(reqCtx context.Context, date string) (*timeTable, error) {
+	params := url.Values{}
+	if date != "" {
+		params.Set("date", date)
+	}
+	body, err := client.doRequest(reqCtx, http.MethodGet, params, nil)
 	if err != nil {
 		return nil, err
 	}
 	return parseTimeTable(body)
 }
 
-func (client *timeTableClient) UpdateTimeTable(timeTable *timeTable) (bool, error) {
+// GetCurrentTimeTable returns the time table of the day of now, or the previous day's if the user is still clocked in within overnightWindow after midnight.
+// The date is sent because the clock of TeamSpirit may be in another time zone than now.
+func (client *timeTableClient) GetCurrentTimeTable(reqCtx context.Context, now time.Time) (*timeTable, error) {
+	tt, err := client.GetTimeTableOn(reqCtx, now.Format(timeTableDateLayout))
+	if err != nil || tt.IsAttending() || convertTime(now).Int64 >= int64(overnightWindow/time.Minute) {
+		return tt, err
+	}
+	prev, err := client.GetTimeTableOn(reqCtx, now.AddDate(0, 0, -1).Format(timeTableDateLayout))
+	if err != nil || !prev.IsAttending() || prev.IsLeaving() {
+		return tt, nil
+	}
+	return prev, nil
+}
+
+func (client *timeTableClient) UpdateTimeTable(reqCtx context.Context, timeTable *timeTable) (bool, error) {
 	timeTable.IsHoliday = nil
+	timeTable.StdEndTime = nil
 	b, err := json.Marshal(timeTable)
 	if err != nil {
 		return false, err
 	}
-	body, err := client.doRequest(http.MethodPost, bytes.NewBuffer(b))
+	body, err := client.doRequest(reqCtx, http.MethodPost, nil, bytes.NewBuffer(b))
 	fmt.Printf("%v %v %v\n", string(body), err, string(body) == `"OK"`)
 	if err != nil {
 		return false, err
@@ -191,13 +354,25 @@ func (client *timeTableClient) UpdateTimeTable(timeTable *timeTable) (bool, erro
 	return string(body) == `"OK"`, nil
 }
 
-func (client *timeTableClient) SetAttendance(attendance bool) (bool, error) {
-	data := map[string]bool{"attendance": attendance}
+type attendanceRequest struct {
+	Attendance bool   `json:"attendance"`
+	Minutes    *int64 `json:"minutes,omitempty"`
+	Date       string `json:"date,omitempty"`
+}
+
+func (client *timeTableClient) SetAttendance(reqCtx context.Context, attendance bool) (bool, error) {
+	return client.SetAttendanceAt(reqCtx, "", attendance, null.Int{})
+}
+
+// SetAttendanceAt punches the work day of the date at the minutes.
+// Today and the server time are used if the date is empty and minutes is null.
+func (client *timeTableClient) SetAttendanceAt(reqCtx context.Context, date string, attendance bool, minutes null.Int) (bool, error) {
+	data := attendanceRequest{Attendance: attendance, Minutes: minutes.Ptr(), Date: date}
 	b, err := json.Marshal(data)
 	if err != nil {
 		return false, err
 	}
-	body, err := client.doRequest(http.MethodPut, bytes.NewBuffer(b))
+	body, err := client.doRequest(reqCtx, http.MethodPut, nil, bytes.NewBuffer(b))
 	if err != nil {
 		return false, err
 	}
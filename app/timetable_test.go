@@ -1,7 +1,10 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 	"time"
@@ -19,6 +22,12 @@ func getMockTime() time.Time {
 	return time.Date(2018, time.September, 1, 11, 12, 22, 0, loc)
 }
 
+// getMockOvernightTime returns a time within overnightWindow of the day of getMockTime
+func getMockOvernightTime() time.Time {
+	loc := time.FixedZone("Asia/Tokyo", 9*60*60)
+	return time.Date(2018, time.September, 1, 3, 12, 22, 0, loc)
+}
+
 func (test Test) Compare(t *testing.T) {
 	if test.expected != test.actual {
 		t.Errorf(`Expected "%v" but got "%v"`, test.expected, test.actual)
@@ -42,9 +51,10 @@ func TestMarshalTimeTable(t *testing.T) {
 }
 
 func TestParseTimeTable(t *testing.T) {
-	timeTable, err := parseTimeTable([]byte(`{"isHoliday": false, "timeTable":[{"from":600, "to": null, "type": 1}, {"from":780, "to": 840, "type": 21}]}`))
+	timeTable, err := parseTimeTable([]byte(`{"isHoliday": false, "timeTable":[{"from":600, "to": null, "type": 1}, {"from":780, "to": 840, "type": 21}], "lastModifiedDate": "2018-09-01 10:00:00"}`))
 	for _, test := range []Test{
 		{nil, err},
+		{"2018-09-01 10:00:00", timeTable.LastModifiedDate},
 		{2, len(timeTable.Items)},
 		{int64(600), timeTable.Items[0].From.ValueOrZero()},
 		{false, timeTable.Items[0].To.Valid},
@@ -183,6 +193,61 @@ func TestUnrest(t *testing.T) {
 	}
 }
 
+func TestAway(t *testing.T) {
+	tt := timeTable{
+		Items: []timeTableItem{
+			{From: null.IntFrom(1), To: null.IntFrom(2), Type: 21},
+		},
+	}
+	res := tt.Away(getMockTime())
+	for _, test := range []Test{
+		{2, len(tt.Items)},
+		{res, true},
+		{int64(672), tt.Items[1].From.ValueOrZero()},
+		{false, tt.Items[1].To.Valid},
+		{22, tt.Items[1].Type},
+		{true, tt.IsResting()},
+		{&tt.Items[1], tt.getOpenRest()},
+	} {
+		test.Compare(t)
+	}
+	tt.AddAway(null.IntFrom(3), null.IntFrom(4))
+	for _, test := range []Test{
+		{3, len(tt.Items)},
+		{int64(4), tt.Items[2].To.ValueOrZero()},
+		{22, tt.Items[2].Type},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestUnrestAway(t *testing.T) {
+	tt := timeTable{
+		Items: []timeTableItem{
+			{From: null.IntFrom(1), Type: 21},
+			{From: null.IntFrom(5), Type: 22},
+		},
+	}
+	res := tt.Unrest(getMockTime())
+	for _, test := range []Test{
+		{2, len(tt.Items)},
+		{res, true},
+		{false, tt.Items[0].To.Valid},
+		{int64(672), tt.Items[1].To.ValueOrZero()},
+		{22, tt.Items[1].Type},
+		{&tt.Items[0], tt.getOpenRest()},
+	} {
+		test.Compare(t)
+	}
+	tt.Unrest(getMockTime())
+	for _, test := range []Test{
+		{2, len(tt.Items)},
+		{true, tt.getOpenRest() == nil},
+	} {
+		test.Compare(t)
+	}
+}
+
 func TestLeave(t *testing.T) {
 	tt := timeTable{
 		Items: []timeTableItem{
@@ -228,3 +293,193 @@ func TestConvertTime(t *testing.T) {
 	result := convertTime(getMockTime())
 	Test{int64(672), result.ValueOrZero()}.Compare(t)
 }
+
+func TestConvertTimeOfWorkDay(t *testing.T) {
+	now := getMockTime()
+	for _, test := range []Test{
+		{int64(672), (&timeTable{}).convertTime(now).Int64},
+		{int64(672), (&timeTable{Date: "2018-09-01"}).convertTime(now).Int64},
+		{int64(2112), (&timeTable{Date: "2018-08-31"}).convertTime(now).Int64},
+		{int64(3552), (&timeTable{Date: "2018-08-30"}).convertTime(now).Int64},
+		{int64(-768), (&timeTable{Date: "2018-09-02"}).convertTime(now).Int64},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestLeaveOvernight(t *testing.T) {
+	tt := &timeTable{
+		Date: "2018-08-31",
+		Items: []timeTableItem{
+			timeTableItem{From: null.IntFrom(1080), Type: 1},
+		},
+	}
+	tt.Leave(getMockTime())
+	Test{int64(2112), tt.Items[0].To.Int64}.Compare(t)
+}
+
+func TestGetCurrentTimeTable(t *testing.T) {
+	var dates []string
+	handler := http.NewServeMux()
+	handler.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		date := r.URL.Query().Get("date")
+		dates = append(dates, date)
+		switch date {
+		case "":
+			w.WriteHeader(http.StatusBadRequest)
+		case "2018-08-31":
+			w.Write([]byte(`{"timeTable":[{"from":1080,"type":1}],"date":"2018-08-31"}`))
+		default:
+			w.Write([]byte(`{"timeTable":[],"date":"` + date + `"}`))
+		}
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := &timeTableClient{
+		HTTPClient: &http.Client{Transport: &http.Transport{}},
+		Endpoint:   server.URL + "/",
+		Timeout:    time.Second,
+	}
+
+	tt, err := client.GetCurrentTimeTable(context.Background(), getMockOvernightTime())
+	for _, test := range []Test{
+		{nil, err},
+		{"2018-08-31", tt.Date},
+		{int64(1632), tt.convertTime(getMockOvernightTime()).Int64},
+		{[]string{"2018-09-01", "2018-08-31"}, dates},
+	} {
+		test.DeepEqual(t)
+	}
+
+	// the previous day is not used after overnightWindow even if it is not left
+	dates = nil
+	tt, err = client.GetCurrentTimeTable(context.Background(), getMockTime())
+	for _, test := range []Test{
+		{nil, err},
+		{"2018-09-01", tt.Date},
+		{[]string{"2018-09-01"}, dates},
+	} {
+		test.DeepEqual(t)
+	}
+
+	dates = nil
+	tt, err = client.GetCurrentTimeTable(context.Background(), getMockOvernightTime().AddDate(0, 0, 2))
+	for _, test := range []Test{
+		{nil, err},
+		{"2018-09-03", tt.Date},
+		{[]string{"2018-09-03", "2018-09-02"}, dates},
+	} {
+		test.DeepEqual(t)
+	}
+
+	// the day of the user is requested even if it is another day in the time zone of TeamSpirit
+	dates = nil
+	now := getMockTime().In(time.FixedZone("Pacific/Honolulu", -10*60*60))
+	tt, err = client.GetCurrentTimeTable(context.Background(), now)
+	for _, test := range []Test{
+		{nil, err},
+		{"2018-08-31", tt.Date},
+		{int64(972), tt.convertTime(now).Int64},
+		{[]string{"2018-08-31"}, dates},
+	} {
+		test.DeepEqual(t)
+	}
+}
+
+func TestTimeTableClientErrors(t *testing.T) {
+	handler := http.NewServeMux()
+	handler.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"timeTable":[{"from":600,"type":1}],"isHoliday":false}`))
+	})
+	handler.HandleFunc("/unauthorized", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`[{"message":"Session expired or invalid","errorCode":"INVALID_SESSION_ID"}]`))
+	})
+	handler.HandleFunc("/apex-error", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`[{"message":"System.NullPointerException","errorCode":"APEX_ERROR"}]`))
+	})
+	handler.HandleFunc("/unavailable", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`<html>Maintenance</html>`))
+	})
+	handler.HandleFunc("/conflict", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`"CONFLICT"`))
+	})
+	handler.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+		w.Write([]byte(`"OK"`))
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	createClient := func(path string) *timeTableClient {
+		return &timeTableClient{
+			HTTPClient: &http.Client{Transport: &http.Transport{}},
+			Endpoint:   server.URL + path,
+			Timeout:    50 * time.Millisecond,
+		}
+	}
+
+	tt, err := createClient("/ok").GetTimeTable(context.Background())
+	for _, test := range []Test{
+		{true, err == nil},
+		{int64(600), tt.Items[0].From.Int64},
+	} {
+		test.Compare(t)
+	}
+
+	_, err = createClient("/unauthorized").GetTimeTable(context.Background())
+	Test{errUnauthorized, err}.Compare(t)
+
+	_, err = createClient("/apex-error").GetTimeTable(context.Background())
+	apiErr, ok := err.(*timeTableError)
+	for _, test := range []Test{
+		{true, ok},
+		{"APEX_ERROR", apiErr.Code},
+		{"System.NullPointerException", apiErr.Message},
+		{http.StatusInternalServerError, apiErr.StatusCode},
+	} {
+		test.Compare(t)
+	}
+
+	ok, err = createClient("/unavailable").SetAttendance(context.Background(), true)
+	apiErr, isAPIErr := err.(*timeTableError)
+	for _, test := range []Test{
+		{false, ok},
+		{true, isAPIErr},
+		{httpErrorCode, apiErr.Code},
+		{"503 Service Unavailable", apiErr.Message},
+		{http.StatusServiceUnavailable, apiErr.StatusCode},
+	} {
+		test.Compare(t)
+	}
+
+	ok, err = createClient("/conflict").UpdateTimeTable(context.Background(), &timeTable{LastModifiedDate: "2018-09-01 10:00:00"})
+	for _, test := range []Test{
+		{false, ok},
+		{errConflict, err},
+	} {
+		test.Compare(t)
+	}
+
+	start := time.Now()
+	ok, err = createClient("/slow").UpdateTimeTable(context.Background(), &timeTable{})
+	for _, test := range []Test{
+		{false, ok},
+		{errTimeout, err},
+		{true, time.Since(start) < time.Second},
+	} {
+		test.Compare(t)
+	}
+
+	client := createClient("/slow")
+	client.Timeout = 0
+	reqCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.GetTimeTable(reqCtx)
+	Test{errTimeout, err}.Compare(t)
+}
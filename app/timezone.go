@@ -0,0 +1,146 @@
+package app
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// timeZoneUnavailable is stored as the time zone of the user when Slack has none, so that users.info is not polled
+const timeZoneUnavailable = "-"
+
+// timeZoneRetryInterval is how long the failure of users.info is stored before it is requested again
+const timeZoneRetryInterval = 24 * time.Hour
+
+// timeZoneMissingScope is the error of users.info with the tokens granted before users:read was requested
+const timeZoneMissingScope = "missing_scope"
+
+func (ctx *Context) getTimeZoneForUser() (string, error) {
+	return ctx.getVariableInHash(ctx.TimeZoneStoreKey, ctx.UserID)
+}
+
+func (ctx *Context) setTimeZone(name string) error {
+	if _, err := time.LoadLocation(name); err != nil {
+		return err
+	}
+	ctx.locationResolved = false
+	return ctx.setVariableInHash(ctx.TimeZoneStoreKey, name)
+}
+
+// fetchSlackTimeZone returns the tz of the user in the Slack profile, which requires the users:read scope
+func (ctx *Context) fetchSlackTimeZone() string {
+	slackToken, err := ctx.getSlackAccessTokenForUser()
+	if err != nil || slackToken == "" {
+		return ""
+	}
+	user, err := slack.New(slackToken).GetUserInfo(ctx.UserID)
+	if err != nil {
+		ctx.setTimeZoneError(err)
+		return ""
+	}
+	if user.TZ == "" || ctx.setTimeZone(user.TZ) != nil {
+		ctx.setVariableInHash(ctx.TimeZoneStoreKey, timeZoneUnavailable)
+		return ""
+	}
+	return user.TZ
+}
+
+// setTimeZoneError stores the failure of users.info with the time to retry as timeZoneUnavailable:retryAt:error
+func (ctx *Context) setTimeZoneError(err error) error {
+	retryAt := ctx.now().Add(timeZoneRetryInterval).Unix()
+	return ctx.setVariableInHash(ctx.TimeZoneStoreKey, timeZoneUnavailable+":"+strconv.FormatInt(retryAt, 10)+":"+err.Error())
+}
+
+// parseTimeZoneError returns the error and the unix time to retry of the failure stored by setTimeZoneError
+func parseTimeZoneError(value string) (string, int64, bool) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 || parts[0] != timeZoneUnavailable {
+		return "", 0, false
+	}
+	retryAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return parts[2], retryAt, true
+}
+
+// resetTimeZoneError deletes the stored failure of users.info, so that the new Slack token is used at once
+func (ctx *Context) resetTimeZoneError() error {
+	name, err := ctx.getTimeZoneForUser()
+	if err != nil {
+		return err
+	}
+	if _, _, ok := parseTimeZoneError(name); !ok {
+		return nil
+	}
+	return ctx.deleteVariableInHash(ctx.TimeZoneStoreKey)
+}
+
+// getLocation returns the location of the user, falling back to the time zone of the server.
+// The time zone of the user is resolved once per context
+func (ctx *Context) getLocation() *time.Location {
+	if !ctx.locationResolved {
+		ctx.location = ctx.resolveLocation()
+		ctx.locationResolved = true
+	}
+	if ctx.location == nil {
+		return ctx.now().Location()
+	}
+	return ctx.location
+}
+
+// resolveLocation returns the stored or Slack time zone of the user, or nil if the user has none
+func (ctx *Context) resolveLocation() *time.Location {
+	name, err := ctx.getTimeZoneForUser()
+	if err != nil {
+		return nil
+	}
+	if _, retryAt, ok := parseTimeZoneError(name); ok && ctx.now().Unix() >= retryAt {
+		name = ""
+	}
+	if name == "" {
+		name = ctx.fetchSlackTimeZone()
+	}
+	if name == "" || strings.HasPrefix(name, timeZoneUnavailable) {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
+
+// localNow returns the current time in the location of the user
+func (ctx *Context) localNow() time.Time {
+	return ctx.now().In(ctx.getLocation())
+}
+
+// nextMorning returns the next time the time table of the new day is used in the location of the user
+func (ctx *Context) nextMorning() time.Time {
+	now := ctx.localNow()
+	year, month, date := now.Date()
+	morning := time.Date(year, month, date, 0, 0, 0, 0, now.Location()).Add(overnightWindow)
+	if !now.Before(morning) {
+		morning = morning.AddDate(0, 0, 1)
+	}
+	return morning
+}
+
+func (ctx *Context) getTimeZoneSlackMessage(input *commandInput) (*message, error) {
+	if len(input.Args) == 0 {
+		text := "現在のタイムゾーンは `" + ctx.getLocation().String() + "` です"
+		name, _ := ctx.getTimeZoneForUser()
+		if reason, _, _ := parseTimeZoneError(name); reason == timeZoneMissingScope {
+			text += "。Slack のプロフィールのタイムゾーンを使うには `/ts login` で再度認証してください :bow:"
+		}
+		return getEphemeralSlackMessage(text), nil
+	}
+	name := input.Args[0]
+	if err := ctx.setTimeZone(name); err != nil {
+		return getErrorSlackMessage("タイムゾーン `" + name + "` は見つかりません。`Asia/Tokyo` や `Europe/Berlin` の形式で指定してください"), nil
+	}
+	return getEphemeralSlackMessage("タイムゾーンを `" + name + "` に設定しました :globe_with_meridians:"), nil
+}
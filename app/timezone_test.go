@@ -0,0 +1,212 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"golang.org/x/oauth2"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func setupUsersInfoGock(tz string) {
+	gock.New("https://slack.com").
+		Post("/api/users.info").
+		BodyString("token=baz&user=FOO").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"ok":   true,
+			"user": map[string]interface{}{"id": "FOO", "tz": tz},
+		})
+}
+
+func TestGetLocation(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/slash", nil)
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	ctx.now = getMockTime
+
+	for _, test := range []Test{
+		{"Asia/Tokyo", ctx.getLocation().String()},
+		{"11:12", ctx.localNow().Format("15:04")},
+	} {
+		test.Compare(t)
+	}
+	timeZone, _ := ctx.getTimeZoneForUser()
+	Test{"", timeZone}.Compare(t)
+
+	ctx.setSlackAccessToken("baz")
+	setupUsersInfoGock("Asia/Singapore")
+	ctx = app.createUserContext("T12345678", "FOO", getMockTime())
+	for _, test := range []Test{
+		{"Asia/Singapore", ctx.getLocation().String()},
+		{"Asia/Singapore", ctx.getLocation().String()},
+		{"10:12", ctx.localNow().Format("15:04")},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+	timeZone, _ = ctx.getTimeZoneForUser()
+	Test{"Asia/Singapore", timeZone}.Compare(t)
+
+	Test{true, ctx.setTimeZone("Foo/Bar") != nil}.Compare(t)
+	Test{nil, ctx.setTimeZone("Europe/Berlin")}.Compare(t)
+	for _, test := range []Test{
+		{"Europe/Berlin", ctx.getLocation().String()},
+		{"04:12", ctx.localNow().Format("15:04")},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestGetLocationWithoutSlackTimeZone(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := app.createUserContext("T12345678", "FOO", getMockTime())
+	ctx.setSlackAccessToken("baz")
+	setupUsersInfoGock("")
+	for _, test := range []Test{
+		{"Asia/Tokyo", ctx.getLocation().String()},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+	timeZone, _ := ctx.getTimeZoneForUser()
+	Test{timeZoneUnavailable, timeZone}.Compare(t)
+
+	setupUsersInfoGock("Asia/Singapore")
+	ctx = app.createUserContext("T12345678", "FOO", getMockTime())
+	for _, test := range []Test{
+		{"Asia/Tokyo", ctx.getLocation().String()},
+		{"11:12", ctx.localNow().Format("15:04")},
+		{true, gock.IsPending()},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestGetLocationWithSlackError(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := app.createUserContext("T12345678", "FOO", getMockTime())
+	ctx.setSlackAccessToken("baz")
+	gock.New("https://slack.com").
+		Post("/api/users.info").
+		BodyString("token=baz&user=FOO").
+		Reply(200).
+		JSON(map[string]interface{}{"ok": false, "error": "missing_scope"})
+	for _, test := range []Test{
+		{"Asia/Tokyo", ctx.getLocation().String()},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+	timeZone, _ := ctx.getTimeZoneForUser()
+	reason, retryAt, ok := parseTimeZoneError(timeZone)
+	for _, test := range []Test{
+		{true, ok},
+		{timeZoneMissingScope, reason},
+		{getMockTime().Add(timeZoneRetryInterval).Unix(), retryAt},
+	} {
+		test.Compare(t)
+	}
+	msg, _ := ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", UserID: "FOO", Text: "tz"})
+	Test{"現在のタイムゾーンは `Asia/Tokyo` です。Slack のプロフィールのタイムゾーンを使うには `/ts login` で再度認証してください :bow:", msg.Text}.Compare(t)
+
+	// the failure is not retried until timeZoneRetryInterval passes
+	setupUsersInfoGock("Asia/Singapore")
+	ctx = app.createUserContext("T12345678", "FOO", getMockTime().Add(time.Hour))
+	for _, test := range []Test{
+		{"Asia/Tokyo", ctx.getLocation().String()},
+		{true, gock.IsPending()},
+	} {
+		test.Compare(t)
+	}
+	ctx = app.createUserContext("T12345678", "FOO", getMockTime().Add(timeZoneRetryInterval))
+	for _, test := range []Test{
+		{"Asia/Singapore", ctx.getLocation().String()},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestResetTimeZoneError(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	ctx := app.createUserContext("T12345678", "FOO", getMockTime())
+	ctx.setTimeZone("Europe/Berlin")
+	Test{nil, ctx.resetTimeZoneError()}.Compare(t)
+	timeZone, _ := ctx.getTimeZoneForUser()
+	Test{"Europe/Berlin", timeZone}.Compare(t)
+	ctx.setTimeZoneError(errors.New(timeZoneMissingScope))
+	Test{nil, ctx.resetTimeZoneError()}.Compare(t)
+	timeZone, _ = ctx.getTimeZoneForUser()
+	Test{"", timeZone}.Compare(t)
+}
+
+func TestTimeZoneSubcommand(t *testing.T) {
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/slash", nil)
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	ctx.now = getMockTime
+
+	for _, test := range []struct {
+		text         string
+		responseType string
+		expected     string
+	}{
+		{"tz", "ephemeral", "現在のタイムゾーンは `Asia/Tokyo` です"},
+		{"tz Foo/Bar", "ephemeral", "タイムゾーン `Foo/Bar` は見つかりません。`Asia/Tokyo` や `Europe/Berlin` の形式で指定してください"},
+		{"tz Europe/Berlin", "ephemeral", "タイムゾーンを `Europe/Berlin` に設定しました :globe_with_meridians:"},
+		{"tz", "ephemeral", "現在のタイムゾーンは `Europe/Berlin` です"},
+	} {
+		msg, err := ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", UserID: "FOO", Text: test.text})
+		for _, test := range []Test{
+			{true, err == nil},
+			{test.responseType, msg.ResponseType},
+			{test.expected, msg.Text},
+		} {
+			test.Compare(t)
+		}
+	}
+}
+
+func TestPunchInTimeZone(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/slash", nil)
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	ctx.now = getMockTime
+	ctx.setSalesforceAccessToken(&oauth2.Token{
+		AccessToken:  "foo",
+		RefreshToken: "bar",
+		TokenType:    "Bearer",
+	})
+	ctx.setTimeZone("Europe/Berlin")
+	setupTimeTableGocks([]timeTableItem{}, &[]bool{false}[0])
+	gock.New("https://teamspirit-1234.cloudforce.test").
+		Put("/services/apexrest/Dakoku").
+		JSON(map[string]interface{}{"attendance": true, "minutes": 252}).
+		Reply(200).
+		BodyString(`"OK"`)
+	msg, err := ctx.getSlackMessage(slack.SlashCommand{TeamID: "T12345678", UserID: "FOO", Text: "in"})
+	for _, test := range []Test{
+		{true, err == nil},
+		{"出勤しました :office:", msg.Text},
+		{true, gock.IsDone()},
+	} {
+		test.Compare(t)
+	}
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+const (
+	// defaultRestWatchdogAfter is how long a rest may stay open before the user is warned
+	defaultRestWatchdogAfter = 90 * time.Minute
+	// restWatchdogCheckInterval is how often the time table of a user is checked while not resting long enough
+	restWatchdogCheckInterval = 10 * time.Minute
+	// restEndOptionStep is the step in minutes of the end times the user can choose from
+	restEndOptionStep = 15
+	// maxSelectOptions is the number of options Slack accepts in a static select
+	maxSelectOptions = 100
+)
+
+// restWatchdog is the state of the open rest warnings of a user
+type restWatchdog struct {
+	// NextCheckAt is the unix time when the time table is checked next
+	NextCheckAt int64 `json:"nextCheckAt"`
+	// WarnedRest identifies the last warned rest, to warn once for each rest
+	WarnedRest string `json:"warnedRest,omitempty"`
+}
+
+func (ctx *Context) getRestWatchdogForUser() *restWatchdog {
+	value, _ := ctx.getVariableInHash(ctx.RestWatchdogStoreKey, ctx.UserID)
+	var watchdog restWatchdog
+	if value == "" || json.Unmarshal([]byte(value), &watchdog) != nil {
+		return &restWatchdog{}
+	}
+	return &watchdog
+}
+
+func (ctx *Context) setRestWatchdog(watchdog *restWatchdog) error {
+	b, err := json.Marshal(watchdog)
+	if err != nil {
+		return err
+	}
+	return ctx.setVariableInHash(ctx.RestWatchdogStoreKey, string(b))
+}
+
+// watchOpenRests warns the users whose rest has been open for longer than RestWatchdogAfter
+func (app *App) watchOpenRests(now time.Time) {
+	tokens, err := app.Store.All(app.SalesforceTokenStoreKey)
+	if err != nil {
+		fmt.Printf("Load Tokens Error: %+v\n", err.Error())
+		return
+	}
+	forEachUser(tokens, func(userID string) {
+		ctx := app.createUserContext("", userID, now)
+		if err := ctx.watchOpenRest(); err != nil {
+			fmt.Printf("Watch Open Rest Error: %+v\n", err.Error())
+		}
+	})
+}
+
+// watchOpenRest sends a DM to end the rest once if the open rest of the user is longer than RestWatchdogAfter
+func (ctx *Context) watchOpenRest() error {
+	if token, err := ctx.getSlackAPIToken(); err != nil || token == "" {
+		return err
+	}
+	watchdog := ctx.getRestWatchdogForUser()
+	if ctx.now().Unix() < watchdog.NextCheckAt {
+		return nil
+	}
+	watchdog.NextCheckAt = ctx.now().Add(restWatchdogCheckInterval).Unix()
+	reqCtx, cancel := ctx.newRequestContext()
+	defer cancel()
+	client, err := ctx.createTimeTableClient(reqCtx)
+	if err != nil {
+		return err
+	}
+	now := ctx.localNow()
+	tt, err := client.GetCurrentTimeTable(reqCtx, now)
+	if err != nil {
+		ctx.setRestWatchdog(watchdog)
+		return err
+	}
+	item := tt.getOpenRest()
+	if item == nil && tt.IsLeaving() {
+		watchdog.NextCheckAt = ctx.nextMorning().Unix()
+		return ctx.setRestWatchdog(watchdog)
+	}
+	if item == nil {
+		// a rest started from now on cannot be open for RestWatchdogAfter before then
+		watchdog.NextCheckAt = ctx.now().Add(ctx.RestWatchdogAfter).Unix()
+		return ctx.setRestWatchdog(watchdog)
+	}
+	minutes := tt.convertTime(now).Int64
+	limit := int64(ctx.RestWatchdogAfter / time.Minute)
+	if elapsed := minutes - item.From.Int64; elapsed < limit {
+		if wait := time.Duration(limit-elapsed) * time.Minute; wait < restWatchdogCheckInterval {
+			watchdog.NextCheckAt = ctx.now().Add(wait).Unix()
+		}
+		return ctx.setRestWatchdog(watchdog)
+	}
+	rest := tt.Date + "/" + strconv.FormatInt(item.From.Int64, 10)
+	if watchdog.WarnedRest == rest {
+		return ctx.setRestWatchdog(watchdog)
+	}
+	watchdog.WarnedRest = rest
+	if err := ctx.setRestWatchdog(watchdog); err != nil {
+		return err
+	}
+	return ctx.sendDirectMessage(getRestWatchdogDirectMessage(item, minutes))
+}
+
+// getRestEndOptions returns the end times of the rest in restEndOptionStep until now
+func getRestEndOptions(from, now int64) []*optionObject {
+	options := []*optionObject{}
+	for minutes := (from/restEndOptionStep + 1) * restEndOptionStep; minutes <= now; minutes += restEndOptionStep {
+		options = append(options, selectOption(formatMinutes(minutes), strconv.FormatInt(minutes, 10)))
+	}
+	if len(options) > maxSelectOptions {
+		options = options[len(options)-maxSelectOptions:]
+	}
+	return options
+}
+
+func getRestWatchdogDirectMessage(item *timeTableItem, now int64) *message {
+	text := fmt.Sprintf("%sを開始してから%sが経過しました。%sを終了しますか？ :hourglass:", item.getLabel(), formatDuration(now-item.From.Int64), item.getLabel())
+	elements := []*blockElement{
+		buttonElement(actionTypeUnrest, "今すぐ終了する", "primary"),
+	}
+	if options := getRestEndOptions(item.From.Int64, now); len(options) > 0 {
+		elements = append(elements, staticSelectElement(actionTypeUnrestAt, "終了した時刻を選択", options...))
+	}
+	return &message{
+		Msg: slack.Msg{Text: text},
+		Blocks: []block{
+			sectionBlock(text),
+			actionsBlock(callbackIDRestWatchdog, elements...),
+		},
+	}
+}
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	null "gopkg.in/guregu/null.v3"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func TestGetRestEndOptions(t *testing.T) {
+	values := func(options []*optionObject) []string {
+		result := []string{}
+		for _, option := range options {
+			result = append(result, option.Text.Text+"="+option.Value)
+		}
+		return result
+	}
+	for _, test := range []Test{
+		{[]string{"12:15=735", "12:30=750", "12:45=765", "13:00=780", "13:15=795", "13:30=810"}, values(getRestEndOptions(720, 815))},
+		{[]string{"12:15=735"}, values(getRestEndOptions(725, 740))},
+		{[]string{}, values(getRestEndOptions(720, 725))},
+		{maxSelectOptions, len(getRestEndOptions(0, 2000))},
+		{"33:15", getRestEndOptions(0, 2000)[maxSelectOptions-1].Text.Text},
+	} {
+		test.DeepEqual(t)
+	}
+}
+
+func TestGetRestWatchdogDirectMessage(t *testing.T) {
+	msg := getRestWatchdogDirectMessage(&timeTableItem{From: null.IntFrom(570), Type: 21}, 672)
+	actions := msg.getActions()
+	for _, test := range []Test{
+		{"休憩を開始してから1時間42分が経過しました。休憩を終了しますか？ :hourglass:", msg.Text},
+		{callbackIDRestWatchdog, msg.Blocks[1].BlockID},
+		{actionTypeUnrest, actions[0].ActionID},
+		{actionTypeUnrestAt, actions[1].ActionID},
+		{6, len(actions[1].Options)},
+	} {
+		test.Compare(t)
+	}
+	msg = getRestWatchdogDirectMessage(&timeTableItem{From: null.IntFrom(661), Type: 22}, 672)
+	for _, test := range []Test{
+		{"私用外出を開始してから11分が経過しました。私用外出を終了しますか？ :hourglass:", msg.Text},
+		{1, len(msg.getActions())},
+	} {
+		test.Compare(t)
+	}
+}
+
+func TestWatchOpenRests(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	ctx := createLoggedInTestContext(app, getMockTime())
+	resting := []timeTableItem{
+		{From: null.IntFrom(540), Type: 1},
+		{From: null.IntFrom(570), Type: 21},
+	}
+
+	// rests started outside ts-dakoku are watched too
+	setupTimeTableGocks(resting, &[]bool{false}[0])
+	setupDirectMessageGock("", "baz")
+	app.watchOpenRests(getMockTime())
+	watchdog := ctx.getRestWatchdogForUser()
+	for _, test := range []Test{
+		{true, gock.IsDone()},
+		{"/570", watchdog.WarnedRest},
+		{getMockTime().Add(restWatchdogCheckInterval).Unix(), watchdog.NextCheckAt},
+	} {
+		test.Compare(t)
+	}
+
+	// warned once for each rest
+	later := getMockTime().Add(restWatchdogCheckInterval)
+	setupTimeTableGocks(resting, &[]bool{false}[0])
+	ctx = createLoggedInTestContext(app, later)
+	Test{nil, ctx.watchOpenRest()}.Compare(t)
+	Test{true, gock.IsDone()}.Compare(t)
+}
+
+func TestWatchOpenRestNotDue(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	for _, test := range []struct {
+		items []timeTableItem
+		wait  time.Duration
+	}{
+		{[]timeTableItem{{From: null.IntFrom(540), Type: 1}, {From: null.IntFrom(600), Type: 21}}, restWatchdogCheckInterval},
+		{[]timeTableItem{{From: null.IntFrom(540), Type: 1}, {From: null.IntFrom(590), Type: 22}}, 8 * time.Minute},
+		{[]timeTableItem{{From: null.IntFrom(540), Type: 1}, {From: null.IntFrom(570), To: null.IntFrom(600), Type: 21}}, app.RestWatchdogAfter},
+		{[]timeTableItem{}, app.RestWatchdogAfter},
+		// checked again at 6:00 of the next day
+		{[]timeTableItem{{From: null.IntFrom(540), To: null.IntFrom(660), Type: 1}}, 18*time.Hour + 47*time.Minute + 38*time.Second},
+	} {
+		app.CleanStore()
+		ctx := createLoggedInTestContext(app, getMockTime())
+		setupTimeTableGocks(test.items, &[]bool{false}[0])
+		for _, test := range []Test{
+			{nil, ctx.watchOpenRest()},
+			{true, gock.IsDone()},
+			{getMockTime().Add(test.wait).Unix(), ctx.getRestWatchdogForUser().NextCheckAt},
+			{"", ctx.getRestWatchdogForUser().WarnedRest},
+		} {
+			test.Compare(t)
+		}
+	}
+}
+
+func TestUnrestAtAction(t *testing.T) {
+	defer gock.Off()
+	app := createMockApp()
+	app.CleanStore()
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks/interactive", strings.NewReader(""))
+	ctx := app.createContext(req)
+	ctx.UserID = "FOO"
+	ctx.now = getMockTime
+	ctx.setSalesforceAccessToken(&oauth2.Token{
+		AccessToken:  "foo",
+		RefreshToken: "bar",
+		TokenType:    "Bearer",
+	})
+	resting := []timeTableItem{
+		{From: null.IntFrom(540), Type: 1},
+		{From: null.IntFrom(570), Type: 21},
+	}
+	for _, test := range []struct {
+		value    string
+		expected string
+	}{
+		{"660", "11:00 に休憩を終了しました :computer:"},
+		{"700", "未来の時刻は指定できません"},
+	} {
+		setupTimeTableGocks(resting, &[]bool{false}[0])
+		if test.value == "660" {
+			gock.New("https://teamspirit-1234.cloudforce.test").
+				Post("/services/apexrest/Dakoku").
+				BodyString(`{"from":570,"to":660,"type":21}`).
+				Reply(200).
+				BodyString(`"OK"`)
+		}
+		msg, responseURL, err := ctx.getActionCallback(&actionCallback{
+			CallbackID:    callbackIDRestWatchdog,
+			ActionName:    actionTypeUnrestAt,
+			SelectedValue: test.value,
+			ResponseURL:   "https://hooks.slack.test/coolhook",
+			UserID:        "FOO",
+		})
+		for _, test := range []Test{
+			{nil, err},
+			{"https://hooks.slack.test/coolhook", responseURL},
+			{test.expected, msg.Text},
+			{true, gock.IsDone()},
+		} {
+			test.Compare(t)
+		}
+	}
+}
@@ -1,8 +1,27 @@
 package main
 
-import "github.com/ngs/ts-dakoku/app"
+import (
+	"fmt"
+	"os"
+
+	"github.com/ngs/ts-dakoku/app"
+)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt-tokens" {
+		count, err := app.ReencryptTokens()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Re-encrypted %d tokens\n", count)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "scheduler" {
+		if err := app.RunScheduler(); err != nil {
+			panic(err)
+		}
+		return
+	}
 	if _, err := app.Run(); err != nil {
 		panic(err)
 	}